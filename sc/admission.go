@@ -0,0 +1,142 @@
+package sc
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//rejectWriteTimeout bounds writing the goodbye message to rejected connections.
+const rejectWriteTimeout = time.Second
+
+//OverloadPolicy decides what happens to new connections while the server serves maxClients connections.
+type OverloadPolicy int
+
+const (
+	//OverloadWait holds an accepted connection until a connection finishes and stops accepting meanwhile.
+	//Further connections wait in the accept backlog of the OS.
+	OverloadWait OverloadPolicy = iota
+
+	//OverloadReject accepts new connections, writes the goodbye message and closes them.
+	OverloadReject
+
+	//OverloadClose accepts new connections and closes them immediately.
+	OverloadClose
+)
+
+func (p OverloadPolicy) String() string {
+	switch p {
+	case OverloadWait:
+		return "wait"
+	case OverloadReject:
+		return "reject"
+	case OverloadClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+//admission is a resizable semaphore limiting the amount of concurrently served connections.
+type admission struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	max    int64
+	cur    int64
+	closed bool
+}
+
+func newAdmission(max int64) *admission {
+	a := &admission{max: max}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+//acquire takes a slot and waits for one to free up if there is none.
+//Returns false if the admission has been closed while waiting.
+func (a *admission) acquire() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for !a.closed && a.max > 0 && a.cur >= a.max {
+		a.cond.Wait()
+	}
+	if a.closed {
+		return false
+	}
+	a.cur++
+	return true
+}
+
+//tryAcquire takes a slot if there is one. Returns false otherwise.
+func (a *admission) tryAcquire() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed || (a.max > 0 && a.cur >= a.max) {
+		return false
+	}
+	a.cur++
+	return true
+}
+
+//release frees a slot taken by acquire or tryAcquire.
+func (a *admission) release() {
+	a.mu.Lock()
+	a.cur--
+	a.mu.Unlock()
+	a.cond.Signal()
+}
+
+func (a *admission) limit() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.max
+}
+
+//setLimit changes the amount of slots. Waiting acquire calls are woken if slots were added.
+func (a *admission) setLimit(max int64) {
+	a.mu.Lock()
+	a.max = max
+	a.mu.Unlock()
+	a.cond.Broadcast()
+}
+
+//close wakes all waiting acquire calls and makes every further acquire fail.
+func (a *admission) close() {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.cond.Broadcast()
+}
+
+//SetOverloadPolicy sets what happens to new connections while maxClients connections are served.
+//goodbye is written to rejected connections for OverloadReject and ignored otherwise.
+//The default is OverloadWait. Has to be called before Start.
+func (server *Server) SetOverloadPolicy(policy OverloadPolicy, goodbye []byte) {
+	server.overloadPolicy = policy
+	server.goodbye = goodbye
+}
+
+//Rejected returns the amount of connections and udp sessions that were rejected because the server was full.
+func (server *Server) Rejected() int64 {
+	return atomic.LoadInt64(&server.rejected)
+}
+
+//reject turns away netConn according to the overload policy of the server.
+func (server *Server) reject(netConn net.Conn) {
+	atomic.AddInt64(&server.rejected, 1)
+	server.log(Event{Kind: EventConnRejected, RemoteAddr: netConn.RemoteAddr(), Message: "server at capacity"})
+
+	if server.overloadPolicy != OverloadReject || len(server.goodbye) == 0 {
+		netConn.Close()
+		return
+	}
+
+	go func() {
+		defer netConn.Close()
+		netConn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		netConn.Write(server.goodbye)
+	}()
+}
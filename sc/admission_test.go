@@ -0,0 +1,126 @@
+package sc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOverloadReject(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 1)
+	server.SetOverloadPolicy(OverloadReject, []byte("busy"))
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		<-ctx.Done()
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Shutdown(context.Background())
+
+	first, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitForClients(t, server, 1)
+
+	second, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := ioutil.ReadAll(second)
+	if err != nil || string(b) != "busy" {
+		t.Fatalf("Expected goodbye message, got %q (%v)", b, err)
+	}
+
+	if server.Rejected() != 1 {
+		t.Fatalf("Expected 1 rejected connection, got %d", server.Rejected())
+	}
+}
+
+func TestOverloadWait(t *testing.T) {
+	served := make(chan struct{}, 2)
+	server := NewTCPServer(0, 0, 1024, 1)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		served <- struct{}{}
+		conn.Read(make([]byte, 1))
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	first, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-served
+
+	second, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	select {
+	case <-served:
+		t.Fatal("Second connection was served while the server was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("Second connection was not served after a slot freed up")
+	}
+	if server.Rejected() != 0 {
+		t.Fatalf("Expected no rejected connections, got %d", server.Rejected())
+	}
+}
+
+func TestOverloadWaitMultipleListeners(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Only linux routes all of 127.0.0.0/8 to the loopback interface")
+	}
+
+	//Waiting listeners must not hold the only slot, whichever listener the client dials.
+	for i := 0; i < 2; i++ {
+		served := make(chan struct{}, 1)
+		server := NewTCPServer(0, 0, 1024, 1)
+		server.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2))
+		server.SetLogger(NopLogger)
+		wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+			defer conn.Close()
+			served <- struct{}{}
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		netConn, err := net.Dial("tcp", server.Addrs()[i].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-served:
+		case <-time.After(time.Second):
+			t.Fatalf("Connection to listener %d was not served", i)
+		}
+		netConn.Close()
+		server.Stop()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,502 @@
+package sc
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//defaultBrokerBufferSize is the outbound buffer size of subscribers if BrokerConfig.BufferSize is not set.
+const defaultBrokerBufferSize = 256
+
+const (
+	brokerSubscribe   = "sub"
+	brokerUnsubscribe = "unsub"
+	brokerPublish     = "pub"
+	brokerMessage     = "msg"
+	brokerAck         = "ack"
+)
+
+//brokerFrame is the envelope of every broker message. Frames are encoded as JSON.
+type brokerFrame struct {
+	Op      string `json:"op"`
+	Topic   string `json:"topic,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+//SlowConsumerPolicy decides what happens to messages for subscribers whose outbound buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	//DropNewest drops the message that does not fit into the buffer anymore.
+	DropNewest SlowConsumerPolicy = iota
+
+	//DropOldest drops the oldest buffered message to make room for the new one.
+	DropOldest
+
+	//Disconnect closes the connection of the slow subscriber.
+	Disconnect
+)
+
+//BrokerConfig configures a Broker.
+type BrokerConfig struct {
+	//BufferSize is the amount of messages buffered per subscriber. Defaults to 256.
+	BufferSize int
+	Policy     SlowConsumerPolicy
+}
+
+//BrokerStats is a snapshot of the counters of a Broker.
+type BrokerStats struct {
+	Subscribers  int
+	Published    int64
+	Delivered    int64
+	Dropped      int64
+	Disconnected int64
+}
+
+//Broker is a publish/subscribe message broker. It is a Handler, serve it with Server.StartHandler
+//and connect to it with a BrokerClient.
+//Topics consist of levels separated by "/". Subscription patterns may contain "+" to match exactly one level
+//and "#" as last level to match any amount of remaining levels, for example "sensors/+/temperature" or "sensors/#".
+type Broker struct {
+	config BrokerConfig
+
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+
+	published    int64
+	delivered    int64
+	dropped      int64
+	disconnected int64
+}
+
+//subscriber is a connection of a Broker with its subscriptions and outbound buffer.
+type subscriber struct {
+	conn *Conn
+
+	mu       sync.Mutex
+	patterns map[string]struct{}
+
+	//sendMu serializes enqueuing, so DropOldest can make room atomically.
+	//out holds published messages, control holds acknowledgements which are never dropped.
+	sendMu  sync.Mutex
+	out     chan *brokerFrame
+	control chan *brokerFrame
+	done    chan struct{}
+	once    sync.Once
+}
+
+//NewBroker is the constructor for a Broker.
+func NewBroker(config BrokerConfig) *Broker {
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBrokerBufferSize
+	}
+	return &Broker{config: config, subscribers: make(map[*subscriber]struct{})}
+}
+
+//ServeConn implements Handler. It serves subscribe, unsubscribe and publish requests of conn until it is closed.
+//Returns an error if the peer sent a malformed frame.
+func (broker *Broker) ServeConn(ctx context.Context, conn *Conn) error {
+	sub := &subscriber{conn: conn,
+		patterns: make(map[string]struct{}),
+		out:      make(chan *brokerFrame, broker.config.BufferSize),
+		control:  make(chan *brokerFrame, 1),
+		done:     make(chan struct{})}
+
+	broker.mu.Lock()
+	broker.subscribers[sub] = struct{}{}
+	broker.mu.Unlock()
+
+	defer func() {
+		broker.mu.Lock()
+		delete(broker.subscribers, sub)
+		broker.mu.Unlock()
+		sub.close()
+	}()
+
+	go sub.writeLoop()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+
+		var frame brokerFrame
+		err = json.Unmarshal(b, &frame)
+		if err != nil {
+			return err
+		}
+
+		switch frame.Op {
+		case brokerSubscribe:
+			ack := &brokerFrame{Op: brokerAck, Topic: frame.Topic}
+			if validTopicPattern(frame.Topic) {
+				sub.mu.Lock()
+				sub.patterns[frame.Topic] = struct{}{}
+				sub.mu.Unlock()
+			} else {
+				ack.Error = ErrInvalidTopicPattern.Error()
+			}
+			sub.sendBlocking(ack)
+
+		case brokerUnsubscribe:
+			sub.mu.Lock()
+			delete(sub.patterns, frame.Topic)
+			sub.mu.Unlock()
+			sub.sendBlocking(&brokerFrame{Op: brokerAck, Topic: frame.Topic})
+
+		case brokerPublish:
+			broker.Publish(frame.Topic, frame.Payload)
+		}
+	}
+}
+
+//Publish sends payload to all subscribers with a pattern matching topic.
+//Returns the amount of subscribers the message was queued for.
+func (broker *Broker) Publish(topic string, payload []byte) int {
+	atomic.AddInt64(&broker.published, 1)
+	frame := &brokerFrame{Op: brokerMessage, Topic: topic, Payload: payload}
+
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+
+	queued := 0
+	for sub := range broker.subscribers {
+		if !sub.matches(topic) {
+			continue
+		}
+		if broker.enqueue(sub, frame) {
+			queued++
+		}
+	}
+	return queued
+}
+
+//Stats returns a snapshot of the counters of the broker.
+func (broker *Broker) Stats() BrokerStats {
+	broker.mu.RLock()
+	subscribers := len(broker.subscribers)
+	broker.mu.RUnlock()
+
+	return BrokerStats{Subscribers: subscribers,
+		Published:    atomic.LoadInt64(&broker.published),
+		Delivered:    atomic.LoadInt64(&broker.delivered),
+		Dropped:      atomic.LoadInt64(&broker.dropped),
+		Disconnected: atomic.LoadInt64(&broker.disconnected)}
+}
+
+//enqueue queues frame for sub according to the slow consumer policy. Returns false if the frame was not queued.
+func (broker *Broker) enqueue(sub *subscriber, frame *brokerFrame) bool {
+	sub.sendMu.Lock()
+	defer sub.sendMu.Unlock()
+
+	for {
+		select {
+		case <-sub.done:
+			return false
+		case sub.out <- frame:
+			atomic.AddInt64(&broker.delivered, 1)
+			return true
+		default:
+		}
+
+		switch broker.config.Policy {
+		case DropOldest:
+			select {
+			case <-sub.out:
+				atomic.AddInt64(&broker.dropped, 1)
+			default:
+			}
+		case Disconnect:
+			atomic.AddInt64(&broker.disconnected, 1)
+			sub.conn.log(Event{Kind: EventError, Message: "disconnecting slow subscriber"})
+			sub.close()
+			return false
+		default:
+			atomic.AddInt64(&broker.dropped, 1)
+			return false
+		}
+	}
+}
+
+//sendBlocking queues the control frame regardless of the slow consumer policy.
+func (sub *subscriber) sendBlocking(frame *brokerFrame) {
+	select {
+	case sub.control <- frame:
+	case <-sub.done:
+	}
+}
+
+func (sub *subscriber) matches(topic string) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	for pattern := range sub.patterns {
+		if matchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+//writeLoop writes the queued frames to the connection of sub until sub is closed.
+//Control frames are written before queued messages.
+func (sub *subscriber) writeLoop() {
+	for {
+		var frame *brokerFrame
+		select {
+		case <-sub.done:
+			return
+		case frame = <-sub.control:
+		default:
+			select {
+			case <-sub.done:
+				return
+			case frame = <-sub.control:
+			case frame = <-sub.out:
+			}
+		}
+
+		b, err := json.Marshal(frame)
+		if err != nil {
+			continue
+		}
+		err = sub.conn.WriteMessage(b)
+		if err != nil {
+			sub.close()
+			return
+		}
+	}
+}
+
+func (sub *subscriber) close() {
+	sub.once.Do(func() {
+		close(sub.done)
+		sub.conn.Close()
+	})
+}
+
+//validTopicPattern reports whether pattern is a valid subscription pattern.
+func validTopicPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+
+	levels := strings.Split(pattern, "/")
+	for i, level := range levels {
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+		if level != "#" && level != "+" && strings.ContainsAny(level, "#+") {
+			return false
+		}
+	}
+	return true
+}
+
+//matchTopic reports whether topic matches the subscription pattern.
+func matchTopic(pattern, topic string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range patternLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(patternLevels) == len(topicLevels)
+}
+
+//BrokerClient subscribes to and publishes on a Broker. BrokerClient is safe for concurrent use.
+type BrokerClient struct {
+	conn *Conn
+
+	//requestMu keeps requests in the order their acks are registered, without blocking readLoop on mu.
+	requestMu sync.Mutex
+
+	mu       sync.Mutex
+	handlers map[string]func(topic string, payload []byte)
+	acks     []chan string
+	err      error
+	done     chan struct{}
+}
+
+//NewBrokerClient is the constructor for a BrokerClient using conn, for example a connection returned by Client.Dial.
+//It starts reading from conn right away, so conn must not be read from by anyone else.
+func NewBrokerClient(conn *Conn) *BrokerClient {
+	brokerClient := &BrokerClient{conn: conn,
+		handlers: make(map[string]func(string, []byte)),
+		done:     make(chan struct{})}
+	go brokerClient.readLoop()
+	return brokerClient
+}
+
+//DialBroker dials the broker with client and returns a BrokerClient for the connection.
+func DialBroker(ctx context.Context, client *Client) (*BrokerClient, error) {
+	conn, err := client.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewBrokerClient(conn), nil
+}
+
+//Subscribe subscribes to all topics matching pattern and waits for the broker to confirm the subscription.
+//handle is called from a single routine for every received message, so it should not block.
+//Subscribing to a pattern again replaces its handler.
+func (brokerClient *BrokerClient) Subscribe(pattern string, handle func(topic string, payload []byte)) error {
+	if !validTopicPattern(pattern) {
+		return ErrInvalidTopicPattern
+	}
+
+	brokerClient.mu.Lock()
+	brokerClient.handlers[pattern] = handle
+	brokerClient.mu.Unlock()
+
+	err := brokerClient.request(brokerSubscribe, pattern)
+	if err != nil {
+		brokerClient.mu.Lock()
+		delete(brokerClient.handlers, pattern)
+		brokerClient.mu.Unlock()
+	}
+	return err
+}
+
+//Unsubscribe removes the subscription of pattern and waits for the broker to confirm it.
+func (brokerClient *BrokerClient) Unsubscribe(pattern string) error {
+	err := brokerClient.request(brokerUnsubscribe, pattern)
+
+	brokerClient.mu.Lock()
+	delete(brokerClient.handlers, pattern)
+	brokerClient.mu.Unlock()
+	return err
+}
+
+//Publish sends payload on topic. Publish does not wait for the message to be delivered.
+func (brokerClient *BrokerClient) Publish(topic string, payload []byte) error {
+	return brokerClient.send(&brokerFrame{Op: brokerPublish, Topic: topic, Payload: payload})
+}
+
+//Close closes the connection to the broker.
+func (brokerClient *BrokerClient) Close() error {
+	brokerClient.fail(errClosedConn)
+	return brokerClient.conn.Close()
+}
+
+//request sends a subscribe or unsubscribe frame and waits for its acknowledgement.
+func (brokerClient *BrokerClient) request(op, pattern string) error {
+	ack := make(chan string, 1)
+
+	//The ack has to be registered before sending, acks are answered in order.
+	brokerClient.requestMu.Lock()
+	brokerClient.mu.Lock()
+	if brokerClient.err != nil {
+		brokerClient.mu.Unlock()
+		brokerClient.requestMu.Unlock()
+		return brokerClient.err
+	}
+	brokerClient.acks = append(brokerClient.acks, ack)
+	brokerClient.mu.Unlock()
+
+	err := brokerClient.send(&brokerFrame{Op: op, Topic: pattern})
+	brokerClient.requestMu.Unlock()
+	if err != nil {
+		brokerClient.removeAck(ack)
+		return err
+	}
+
+	select {
+	case msg := <-ack:
+		if msg != "" {
+			return &RemoteError{Method: op, Message: msg}
+		}
+		return nil
+	case <-brokerClient.done:
+		return brokerClient.err
+	}
+}
+
+//removeAck unregisters the ack of a request which could not be sent.
+func (brokerClient *BrokerClient) removeAck(ack chan string) {
+	brokerClient.mu.Lock()
+	defer brokerClient.mu.Unlock()
+
+	for i, registered := range brokerClient.acks {
+		if registered == ack {
+			brokerClient.acks = append(brokerClient.acks[:i], brokerClient.acks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (brokerClient *BrokerClient) send(frame *brokerFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return brokerClient.conn.WriteMessage(b)
+}
+
+//readLoop dispatches messages and acknowledgements until the connection fails.
+func (brokerClient *BrokerClient) readLoop() {
+	for {
+		b, err := brokerClient.conn.ReadMessage()
+		if err != nil {
+			brokerClient.fail(err)
+			return
+		}
+
+		var frame brokerFrame
+		err = json.Unmarshal(b, &frame)
+		if err != nil {
+			brokerClient.fail(err)
+			brokerClient.conn.Close()
+			return
+		}
+
+		switch frame.Op {
+		case brokerAck:
+			brokerClient.mu.Lock()
+			if len(brokerClient.acks) > 0 {
+				brokerClient.acks[0] <- frame.Error
+				brokerClient.acks = brokerClient.acks[1:]
+			}
+			brokerClient.mu.Unlock()
+
+		case brokerMessage:
+			brokerClient.mu.Lock()
+			handlers := make([]func(string, []byte), 0, 1)
+			for pattern, handle := range brokerClient.handlers {
+				if matchTopic(pattern, frame.Topic) {
+					handlers = append(handlers, handle)
+				}
+			}
+			brokerClient.mu.Unlock()
+
+			for _, handle := range handlers {
+				handle(frame.Topic, frame.Payload)
+			}
+		}
+	}
+}
+
+//fail marks the client as unusable. Only the first error is kept.
+func (brokerClient *BrokerClient) fail(err error) {
+	brokerClient.mu.Lock()
+	defer brokerClient.mu.Unlock()
+
+	if brokerClient.err != nil {
+		return
+	}
+	brokerClient.err = err
+	close(brokerClient.done)
+}
@@ -0,0 +1,174 @@
+package sc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{desc: "exact", pattern: "a/b", topic: "a/b", match: true},
+		{desc: "exact mismatch", pattern: "a/b", topic: "a/c", match: false},
+		{desc: "single level", pattern: "a/+/c", topic: "a/b/c", match: true},
+		{desc: "single level too short", pattern: "a/+", topic: "a", match: false},
+		{desc: "single level too long", pattern: "a/+", topic: "a/b/c", match: false},
+		{desc: "multi level", pattern: "a/#", topic: "a/b/c", match: true},
+		{desc: "multi level parent", pattern: "a/#", topic: "a", match: true},
+		{desc: "multi level all", pattern: "#", topic: "a/b", match: true},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			if got := matchTopic(tC.pattern, tC.topic); got != tC.match {
+				t.Fatalf("matchTopic(%q, %q) = %v, expected %v", tC.pattern, tC.topic, got, tC.match)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"", "a/#/b", "a/b+", "a#"} {
+		if validTopicPattern(pattern) {
+			t.Fatalf("Expected pattern %q to be invalid", pattern)
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(BrokerConfig{})
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.StartHandler(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Shutdown(context.Background())
+
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), addrPort(server.Addr()), time.Second, 1024)
+	subscriber, err := DialBroker(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	publisher, err := DialBroker(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+
+	received := make(chan string, 10)
+	err = subscriber.Subscribe("sensors/+/temperature", func(topic string, payload []byte) {
+		received <- topic + "=" + string(payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriber.Subscribe("a/#/b", nil); err != ErrInvalidTopicPattern {
+		t.Fatalf("Expected ErrInvalidTopicPattern, got %v", err)
+	}
+
+	publisher.Publish("sensors/kitchen/humidity", []byte("40"))
+	publisher.Publish("sensors/kitchen/temperature", []byte("21"))
+	select {
+	case msg := <-received:
+		if msg != "sensors/kitchen/temperature=21" {
+			t.Fatalf("Unexpected message %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+
+	if err := subscriber.Unsubscribe("sensors/+/temperature"); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Publish("sensors/kitchen/temperature", []byte("22")); n != 0 {
+		t.Fatalf("Expected no subscribers after unsubscribe, got %d", n)
+	}
+
+	stats := broker.Stats()
+	if stats.Subscribers != 2 || stats.Published != 3 || stats.Delivered != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBrokerSlowConsumer(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		policy SlowConsumerPolicy
+		first  string
+	}{
+		{desc: "drop newest", policy: DropNewest, first: "0"},
+		{desc: "drop oldest", policy: DropOldest, first: "2"},
+		{desc: "disconnect", policy: Disconnect},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			broker := NewBroker(BrokerConfig{BufferSize: 2, Policy: tC.policy})
+			local, remote := net.Pipe()
+			conn := NewConn(local, 0, 1024)
+			sub := &subscriber{conn: conn,
+				patterns: map[string]struct{}{"#": {}},
+				out:      make(chan *brokerFrame, 2),
+				control:  make(chan *brokerFrame, 1),
+				done:     make(chan struct{})}
+			broker.subscribers[sub] = struct{}{}
+			defer remote.Close()
+			defer sub.close()
+
+			for _, payload := range []string{"0", "1", "2", "3"} {
+				broker.Publish("t", []byte(payload))
+			}
+
+			stats := broker.Stats()
+			if tC.policy == Disconnect {
+				if stats.Disconnected != 1 || !isClosedChan(sub.done) {
+					t.Fatalf("Expected slow subscriber to be disconnected, got %+v", stats)
+				}
+				return
+			}
+			if stats.Dropped != 2 {
+				t.Fatalf("Expected 2 dropped messages, got %+v", stats)
+			}
+			if frame := <-sub.out; string(frame.Payload) != tC.first {
+				t.Fatalf("Expected first buffered message %s, got %s", tC.first, frame.Payload)
+			}
+		})
+	}
+}
+
+func TestBrokerDropOldestKeepsAcks(t *testing.T) {
+	broker := NewBroker(BrokerConfig{BufferSize: 1, Policy: DropOldest})
+	local, remote := net.Pipe()
+	conn := NewConn(local, 0, 1024)
+	sub := &subscriber{conn: conn,
+		patterns: map[string]struct{}{"#": {}},
+		out:      make(chan *brokerFrame, 1),
+		control:  make(chan *brokerFrame, 1),
+		done:     make(chan struct{})}
+	broker.subscribers[sub] = struct{}{}
+	defer remote.Close()
+	defer sub.close()
+
+	sub.sendBlocking(&brokerFrame{Op: brokerAck, Topic: "#"})
+	for _, payload := range []string{"0", "1", "2"} {
+		broker.Publish("t", []byte(payload))
+	}
+
+	select {
+	case frame := <-sub.control:
+		if frame.Op != brokerAck {
+			t.Fatalf("Expected ack, got %+v", frame)
+		}
+	default:
+		t.Fatal("Expected ack to survive dropping messages")
+	}
+	if frame := <-sub.out; string(frame.Payload) != "2" {
+		t.Fatalf("Expected newest message 2, got %s", frame.Payload)
+	}
+}
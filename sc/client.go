@@ -1,9 +1,10 @@
 package sc
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
 	"net"
-	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,13 +15,37 @@ import (
 type Client struct {
 	remoteAddr           net.IP
 	remotePort           int
+	remotePath           string
+	remoteHost           string
+	dialTimeout          time.Duration
 	defaultTimeout       time.Duration
+	readTimeout          time.Duration
+	writeTimeout         time.Duration
 	defaultMaxReadBuffer int64
 	proto                protocol
+
+	//tlsConfig is nil for plain text clients.
+	tlsConfig *tls.Config
+
+	//heartbeat is started on every dialed connection if its interval is set.
+	heartbeat HeartbeatConfig
+
+	//logger receives the events of the client and its connections.
+	logger Logger
+
+	//compression is offered to the server if methods are set.
+	compression CompressionConfig
+
+	//dialer replaces net.Dialer if set.
+	dialer DialFunc
+
+	//backoff and onStateChange are used by ConnectReconnecting.
+	backoff       Backoff
+	onStateChange func(ConnState, error)
 }
 
 //NewClient is the constructor for a networking client
-//A defaultTimeout of 0 means that the connection does not time out.
+//defaultTimeout is the idle timeout of the connection. A defaultTimeout of 0 means that the connection does not time out.
 //If the connection uses a limited read or not has to be decided in the passed handle method.
 func NewClient(remoteAddr net.IP, remotePort int, defaultTimeout time.Duration, defaultMaxReadBuffer int64, proto protocol) *Client {
 	return &Client{remoteAddr: remoteAddr,
@@ -48,28 +73,206 @@ func NewUDPClient(remoteAddr net.IP, remotePort int, defaultTimeout time.Duratio
 		proto:                udp}
 }
 
+//NewUnixClient is the constructor for a client connecting to the unix domain socket at path.
+func NewUnixClient(path string, defaultTimeout time.Duration, defaultMaxReadBuffer int64) *Client {
+	return &Client{remotePath: path,
+		defaultTimeout:       defaultTimeout,
+		defaultMaxReadBuffer: defaultMaxReadBuffer,
+		proto:                unix}
+}
+
+//NewUnixPacketClient is the constructor for a client connecting to the sequenced packet unix domain socket at path.
+func NewUnixPacketClient(path string, defaultTimeout time.Duration, defaultMaxReadBuffer int64) *Client {
+	client := NewUnixClient(path, defaultTimeout, defaultMaxReadBuffer)
+	client.proto = unixpacket
+	return client
+}
+
+//NewTLSClient is the constructor for a tcp client that connects over TLS.
+//If tlsConfig.ServerName is empty, remoteAddr is used to verify the server certificate.
+//For mutual TLS the client certificate has to be set in tlsConfig.Certificates.
+func NewTLSClient(remoteAddr net.IP, remotePort int, tlsConfig *tls.Config, defaultTimeout time.Duration, defaultMaxReadBuffer int64) *Client {
+	client := NewTCPClient(remoteAddr, remotePort, defaultTimeout, defaultMaxReadBuffer)
+	client.tlsConfig = tlsConfig
+	return client
+}
+
+//SetRemoteHost sets a hostname that is resolved on every dial instead of the remote ip of the client.
+//An empty host switches back to the remote ip. Has no effect on unix domain socket clients.
+//For TLS clients host is used to verify the server certificate if tlsConfig.ServerName is empty.
+func (client *Client) SetRemoteHost(host string) {
+	client.remoteHost = host
+}
+
+//DialTimeout is the getter for the dial timeout of the client.
+//If no dial timeout is set, the defaultTimeout of the client is used.
+func (client *Client) DialTimeout() time.Duration {
+	if client.dialTimeout > 0 {
+		return client.dialTimeout
+	}
+	return client.defaultTimeout
+}
+
+//SetDialTimeout is the setter for the dial timeout of the client.
+//The dial timeout covers resolving the host, connecting and the TLS handshake.
+func (client *Client) SetDialTimeout(dialTimeout time.Duration) {
+	client.dialTimeout = dialTimeout
+}
+
+//SetReadTimeout sets the default read timeout of dialed connections.
+//It can be overridden per connection with conn.SetReadTimeout.
+func (client *Client) SetReadTimeout(timeout time.Duration) {
+	client.readTimeout = timeout
+}
+
+//SetWriteTimeout sets the default write timeout of dialed connections.
+//It can be overridden per connection with conn.SetWriteTimeout.
+func (client *Client) SetWriteTimeout(timeout time.Duration) {
+	client.writeTimeout = timeout
+}
+
+//SetHeartbeat enables heartbeats with config on every dialed connection, see Conn.StartHeartbeat.
+//The server has to enable heartbeats as well.
+func (client *Client) SetHeartbeat(config HeartbeatConfig) {
+	client.heartbeat = config
+}
+
+//SetLogger sets the logger receiving the events of the client and its connections.
+//The default logger writes to the standard logger of the log package, use NopLogger to silence the client.
+func (client *Client) SetLogger(logger Logger) {
+	client.logger = logger
+}
+
+//DialFunc establishes the raw connection of a Client, see Client.SetDialer.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+//SetDialer replaces the dialer of the client, for example with MemListener.DialContext.
+//TLS, timeouts and the other settings of the client are applied to the dialed connections as usual.
+func (client *Client) SetDialer(dialer DialFunc) {
+	client.dialer = dialer
+}
+
+//SetCompression enables the compression handshake on every dialed connection. The server has to enable it as well,
+//see Server.SetCompression. The first method of config.Methods supported by the server is used.
+//Writes on a compressed connection have to be flushed with conn.Flush, WriteMessage flushes automatically.
+//udp and unixpacket clients ignore the compression.
+func (client *Client) SetCompression(config CompressionConfig) {
+	client.compression = config
+}
+
+//Dial connects to the server and returns the established connection.
+//Dial is bound by ctx and the dial timeout of the client. On failure a *DialError is returned,
+//use errors.As to inspect the underlying error, for example a *net.DNSError if the host could not be resolved.
+//The returned connection has to be closed by the caller.
+func (client *Client) Dial(ctx context.Context) (*Conn, error) {
+	addr := client.address()
+
+	if timeout := client.DialTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	dial := client.dialer
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	netConn, err := dial(ctx, client.proto.String(), addr)
+	if err != nil {
+		return nil, client.dialError("dial", addr, err)
+	}
+
+	if client.tlsConfig != nil {
+		tlsConfig := client.tlsConfig
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = client.host()
+		}
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	conn := NewConn(netConn, client.defaultTimeout, client.defaultMaxReadBuffer)
+	conn.readTimeout = client.readTimeout
+	conn.writeTimeout = client.writeTimeout
+	conn.logger = client.getLogger()
+
+	err = conn.handshake(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, client.dialError("handshake", addr, err)
+	}
+	if len(client.compression.Methods) > 0 && !client.proto.messageBased() {
+		err = conn.negotiateCompression(ctx, client.compression, true)
+		if err != nil {
+			conn.Close()
+			return nil, client.dialError("compression", addr, err)
+		}
+	}
+	conn.markOpened()
+	conn.StartHeartbeat(client.heartbeat)
+	return conn, nil
+}
+
+//dialError builds the *DialError of a failed dial and emits it as an event.
+func (client *Client) dialError(op, addr string, err error) error {
+	dialErr := &DialError{Op: op, Network: client.proto.String(), Addr: addr, Err: err}
+	client.getLogger().Log(Event{Kind: EventDialFailed, Time: time.Now(), Message: addr, Err: dialErr})
+	return dialErr
+}
+
+//getLogger returns the logger of the client or the default logger if none was set.
+func (client *Client) getLogger() Logger {
+	if client.logger == nil {
+		return defaultLogger
+	}
+	return client.logger
+}
+
 //Connect is the exported api for the connect method. Is run in its' own routine.
 //After the spawned routine ends, that is when the passed handle func returns, waitgroup.Done is called on the returned waitgroup.
-//For using the built in timeout, look at net.Conn.SetDeadline .
+//If the connection can not be established, the *DialError is sent on the returned channel and handle is not called.
+//The channel is closed once the dial attempt is finished.
+//The timeouts of the client are applied automatically on every read and write of the connection.
 //The opened connection is not automatically closed. This has to be part of the passed handle function.
-func (client *Client) Connect(handle func(*Conn, ...interface{}), a ...interface{}) *sync.WaitGroup {
+func (client *Client) Connect(handle func(*Conn, ...interface{}), a ...interface{}) (*sync.WaitGroup, <-chan error) {
 	var clientWaitGroup sync.WaitGroup
+	errChan := make(chan error, 1)
 	clientWaitGroup.Add(1)
-	go client.connect(&clientWaitGroup, handle, a...)
-	return &clientWaitGroup
+	go client.connect(&clientWaitGroup, errChan, handle, a...)
+	return &clientWaitGroup, errChan
 }
 
-func (client *Client) connect(clientWaitGroup *sync.WaitGroup, handle func(*Conn, ...interface{}), a ...interface{}) {
+func (client *Client) connect(clientWaitGroup *sync.WaitGroup, errChan chan<- error, handle func(*Conn, ...interface{}), a ...interface{}) {
 	defer clientWaitGroup.Done()
 
-	addr := netutil.BuildIPAddressString(client.remoteAddr, client.remotePort)
-	netConn, err := net.Dial(client.proto.String(), addr)
+	conn, err := client.Dial(context.Background())
 	if err != nil {
-		log.Printf("Establishing a conn with [%s over %s] failed: %s", addr, client.proto, err)
-		runtime.Goexit()
+		errChan <- err
+		close(errChan)
+		return
 	}
-
-	conn := NewConn(netConn, client.defaultTimeout, client.defaultMaxReadBuffer)
+	close(errChan)
 
 	handle(conn, a...)
 }
+
+//host returns the hostname or ip of the server.
+func (client *Client) host() string {
+	if client.remoteHost != "" {
+		return client.remoteHost
+	}
+	return client.remoteAddr.String()
+}
+
+//address returns the address of the server in the format expected by net.Dial.
+func (client *Client) address() string {
+	switch {
+	case client.proto.unixBased():
+		return client.remotePath
+	case client.remoteHost != "":
+		return net.JoinHostPort(client.remoteHost, strconv.Itoa(client.remotePort))
+	default:
+		return netutil.BuildIPAddressString(client.remoteAddr, client.remotePort)
+	}
+}
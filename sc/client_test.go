@@ -1 +1,92 @@
-package sc
\ No newline at end of file
+package sc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientDialHostname(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Write([]byte("hi"))
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	client := NewTCPClient(nil, addrPort(server.Addr()), time.Second, 1024)
+	client.SetRemoteHost("localhost")
+	conn, err := client.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	b := make([]byte, 2)
+	if _, err := conn.Read(b); err != nil || string(b) != "hi" {
+		t.Fatalf("Expected hi, got %q (%v)", b, err)
+	}
+}
+
+func TestClientDialErrors(t *testing.T) {
+	port := freePort(t)
+	refused := NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024)
+
+	unresolvable := NewTCPClient(nil, port, time.Second, 1024)
+	unresolvable.SetRemoteHost("sc.invalid")
+
+	testCases := []struct {
+		desc   string
+		client *Client
+		check  func(error) bool
+	}{
+		{
+			desc:   "Connection refused",
+			client: refused,
+			check:  func(err error) bool { return true },
+		},
+		{
+			desc:   "Unresolvable host",
+			client: unresolvable,
+			check: func(err error) bool {
+				var dnsErr *net.DNSError
+				return errors.As(err, &dnsErr)
+			},
+		},
+	}
+
+	for _, tC := range testCases {
+		_, err := tC.client.Dial(context.Background())
+		var dialErr *DialError
+		if !errors.As(err, &dialErr) || dialErr.Op != "dial" {
+			t.Fatalf("%s: Expected *DialError, got %v", tC.desc, err)
+		}
+		if !tC.check(err) {
+			t.Fatalf("%s: Unexpected underlying error %v", tC.desc, err)
+		}
+	}
+}
+
+func TestClientConnectReportsDialError(t *testing.T) {
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), freePort(t), time.Second, 1024)
+	called := false
+	wg, errChan := client.Connect(func(conn *Conn, a ...interface{}) {
+		called = true
+	})
+
+	err := <-errChan
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("Expected *DialError, got %v", err)
+	}
+	wg.Wait()
+	if called {
+		t.Fatal("Handle must not be called on dial failure")
+	}
+}
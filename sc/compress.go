@@ -0,0 +1,331 @@
+package sc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//closeFlushTimeout bounds sending the end of the compression stream when a compressed connection is closed.
+const closeFlushTimeout = time.Second
+
+//Compression is a streaming compression method of a connection.
+type Compression byte
+
+const (
+	//CompressionNone leaves the connection uncompressed.
+	CompressionNone Compression = iota
+	CompressionFlate
+	CompressionGzip
+)
+
+//compressionMagic starts the compression handshake of both peers. Its last byte is the version of the handshake.
+var compressionMagic = []byte{'S', 'C', 'Z', 1}
+
+//String returns the name of the compression method.
+func (compression Compression) String() string {
+	switch compression {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionGzip:
+		return "gzip"
+	default:
+		return "unknown"
+	}
+}
+
+//CompressionConfig configures the compression handshake of a Server or Client.
+type CompressionConfig struct {
+	//Methods are the supported methods. The client lists them in order of preference,
+	//the server picks the first method of the client it supports. CompressionNone is always supported.
+	Methods []Compression
+	//Level is the compression level as defined by compress/flate. 0 and invalid levels mean flate.DefaultCompression.
+	Level int
+}
+
+//CompressionStats reports the effect of the compression of a connection.
+//BytesRead and BytesWritten count uncompressed bytes, WireBytesRead and WireBytesWritten the bytes on the wire.
+type CompressionStats struct {
+	Method           Compression
+	BytesRead        int64
+	BytesWritten     int64
+	WireBytesRead    int64
+	WireBytesWritten int64
+}
+
+//Saved returns the amount of bytes saved on the wire by the compression. It is negative for incompressible data.
+func (stats CompressionStats) Saved() int64 {
+	return stats.BytesRead + stats.BytesWritten - stats.WireBytesRead - stats.WireBytesWritten
+}
+
+//CompressionStats returns the compression statistics of the connection.
+//The method is CompressionNone and the counters are zero if the connection is not compressed.
+func (c *Conn) CompressionStats() CompressionStats {
+	compressed, ok := c.Conn.(*compressedConn)
+	if !ok {
+		return CompressionStats{}
+	}
+	return CompressionStats{Method: compressed.method,
+		BytesRead:        atomic.LoadInt64(&compressed.bytesRead),
+		BytesWritten:     atomic.LoadInt64(&compressed.bytesWritten),
+		WireBytesRead:    atomic.LoadInt64(&compressed.wireConn.bytesRead),
+		WireBytesWritten: atomic.LoadInt64(&compressed.wireConn.bytesWritten)}
+}
+
+//Flush sends all data buffered by the compression of the connection to the peer.
+//Writes on a compressed connection are buffered until Flush is called, WriteMessage flushes automatically.
+//Flush is a no-op for uncompressed connections.
+func (c *Conn) Flush() error {
+	compressed, ok := c.Conn.(*compressedConn)
+	if !ok {
+		return nil
+	}
+
+	deadline, ok := c.deadline(c.WriteTimeout())
+	if ok {
+		err := compressed.SetWriteDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	return wrapTimeout("write", compressed.Flush())
+}
+
+//negotiateCompression runs the compression handshake on c and wraps c in the negotiated compression.
+//The handshake is bound by ctx and the idle timeout of c, like the TLS handshake.
+func (c *Conn) negotiateCompression(ctx context.Context, config CompressionConfig, isClient bool) error {
+	release, err := c.bindToContext(ctx, c.Conn)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var method Compression
+	if isClient {
+		method, err = c.offerCompression(config.Methods)
+	} else {
+		method, err = c.chooseCompression(config.Methods)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	if method != CompressionNone {
+		c.Conn = newCompressedConn(c.Conn, method, config.Level)
+	}
+	return nil
+}
+
+//offerCompression sends the supported methods and reads the method chosen by the server.
+func (c *Conn) offerCompression(methods []Compression) (Compression, error) {
+	offer := append([]byte{}, compressionMagic...)
+	offer = append(offer, byte(len(methods)))
+	for _, method := range methods {
+		offer = append(offer, byte(method))
+	}
+	_, err := c.Conn.Write(offer)
+	if err != nil {
+		return CompressionNone, err
+	}
+
+	answer := make([]byte, len(compressionMagic)+1)
+	_, err = io.ReadFull(c.Conn, answer)
+	if err != nil {
+		return CompressionNone, err
+	}
+	if !bytes.Equal(answer[:len(compressionMagic)], compressionMagic) {
+		return CompressionNone, ErrCompressionHandshake
+	}
+
+	chosen := Compression(answer[len(compressionMagic)])
+	if chosen != CompressionNone && !containsCompression(methods, chosen) {
+		return CompressionNone, ErrCompressionHandshake
+	}
+	return chosen, nil
+}
+
+//chooseCompression reads the offer of the client and answers with the first offered method in methods.
+func (c *Conn) chooseCompression(methods []Compression) (Compression, error) {
+	header := make([]byte, len(compressionMagic)+1)
+	_, err := io.ReadFull(c.Conn, header)
+	if err != nil {
+		return CompressionNone, err
+	}
+	if !bytes.Equal(header[:len(compressionMagic)], compressionMagic) {
+		return CompressionNone, ErrCompressionHandshake
+	}
+
+	offer := make([]byte, header[len(compressionMagic)])
+	_, err = io.ReadFull(c.Conn, offer)
+	if err != nil {
+		return CompressionNone, err
+	}
+
+	chosen := CompressionNone
+	for _, method := range offer {
+		if containsCompression(methods, Compression(method)) {
+			chosen = Compression(method)
+			break
+		}
+	}
+
+	_, err = c.Conn.Write(append(append([]byte{}, compressionMagic...), byte(chosen)))
+	return chosen, err
+}
+
+func containsCompression(methods []Compression, method Compression) bool {
+	for _, m := range methods {
+		if m == method && (method == CompressionFlate || method == CompressionGzip) {
+			return true
+		}
+	}
+	return false
+}
+
+//wireConn counts the bytes transferred on the wire below the compression.
+type wireConn struct {
+	net.Conn
+	bytesRead    int64
+	bytesWritten int64
+}
+
+func (wire *wireConn) Read(b []byte) (int, error) {
+	n, err := wire.Conn.Read(b)
+	atomic.AddInt64(&wire.bytesRead, int64(n))
+	return n, err
+}
+
+func (wire *wireConn) Write(b []byte) (int, error) {
+	n, err := wire.Conn.Write(b)
+	atomic.AddInt64(&wire.bytesWritten, int64(n))
+	return n, err
+}
+
+//compressor is implemented by *flate.Writer and *gzip.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+//compressedConn compresses all writes and decompresses all reads of the wrapped connection.
+//Deadlines and addresses are the ones of the wrapped connection.
+type compressedConn struct {
+	*wireConn
+	method Compression
+
+	//reader is created on the first Read, since the gzip reader blocks until the header arrived.
+	reader io.Reader
+
+	//closed is set once the compressor was closed. It is guarded by writeMu.
+	writeMu sync.Mutex
+	writer  compressor
+	closed  bool
+
+	//bytesRead and bytesWritten count uncompressed bytes. They are accessed atomically.
+	bytesRead    int64
+	bytesWritten int64
+}
+
+func newCompressedConn(conn net.Conn, method Compression, level int) *compressedConn {
+	if level == 0 || level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+
+	compressed := &compressedConn{wireConn: &wireConn{Conn: conn}, method: method}
+	//The errors can be ignored, they are only returned for invalid levels.
+	switch method {
+	case CompressionGzip:
+		compressed.writer, _ = gzip.NewWriterLevel(compressed.wireConn, level)
+	default:
+		compressed.writer, _ = flate.NewWriter(compressed.wireConn, level)
+	}
+	return compressed
+}
+
+//Read reads decompressed data. A peer that closed its connection ends the stream with io.EOF,
+//a stream that breaks off before its end is reported as io.ErrUnexpectedEOF.
+func (compressed *compressedConn) Read(b []byte) (int, error) {
+	if compressed.reader == nil {
+		switch compressed.method {
+		case CompressionGzip:
+			reader, err := gzip.NewReader(compressed.wireConn)
+			if err != nil {
+				return 0, err
+			}
+			compressed.reader = reader
+		default:
+			compressed.reader = flate.NewReader(compressed.wireConn)
+		}
+	}
+
+	n, err := compressed.reader.Read(b)
+	atomic.AddInt64(&compressed.bytesRead, int64(n))
+	return n, err
+}
+
+//Write compresses b into the buffer of the compressor. The data is sent on Flush.
+func (compressed *compressedConn) Write(b []byte) (int, error) {
+	compressed.writeMu.Lock()
+	defer compressed.writeMu.Unlock()
+
+	if compressed.closed {
+		return 0, errClosedConn
+	}
+	n, err := compressed.writer.Write(b)
+	atomic.AddInt64(&compressed.bytesWritten, int64(n))
+	return n, err
+}
+
+//Flush sends the buffered compressed data.
+func (compressed *compressedConn) Flush() error {
+	compressed.writeMu.Lock()
+	defer compressed.writeMu.Unlock()
+
+	if compressed.closed {
+		return errClosedConn
+	}
+	return compressed.writer.Flush()
+}
+
+//Close sends the data written since the last flush and the end of the compression stream, then closes the wire.
+//Sending is bound by closeFlushTimeout, so a peer that stopped reading can not block Close.
+func (compressed *compressedConn) Close() error {
+	//The deadline releases writes that are stuck while holding writeMu.
+	compressed.wireConn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+
+	compressed.writeMu.Lock()
+	if !compressed.closed {
+		compressed.closed = true
+		compressed.writer.Close()
+	}
+	compressed.writeMu.Unlock()
+
+	return compressed.wireConn.Close()
+}
+
+//unwrap returns the wrapped connection.
+func (compressed *compressedConn) unwrap() net.Conn {
+	return compressed.wireConn.Conn
+}
+
+//unwrapConn returns the innermost connection below the wrappers of sc.
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		wrapped, ok := conn.(interface{ unwrap() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = wrapped.unwrap()
+	}
+}
@@ -0,0 +1,192 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		server []Compression
+		client []Compression
+		method Compression
+	}{
+		{desc: "flate", server: []Compression{CompressionFlate, CompressionGzip}, client: []Compression{CompressionFlate}, method: CompressionFlate},
+		{desc: "gzip", server: []Compression{CompressionFlate, CompressionGzip}, client: []Compression{CompressionGzip, CompressionFlate}, method: CompressionGzip},
+		{desc: "no common method", server: []Compression{CompressionFlate}, client: []Compression{CompressionGzip}, method: CompressionNone},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			readErr := make(chan error, 1)
+			server := NewTCPServer(0, 0, 1<<20, 0)
+			server.SetLogger(NopLogger)
+			server.SetCompression(CompressionConfig{Methods: tC.server})
+			wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+				defer conn.Close()
+				for {
+					msg, err := conn.ReadMessage()
+					if err != nil {
+						readErr <- err
+						return nil
+					}
+					conn.WriteMessage(msg)
+				}
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wg.Wait()
+			defer server.Stop()
+
+			client := NewTCPClient(net.IPv4(127, 0, 0, 1), server.Addr().(*net.TCPAddr).Port, 0, 1<<20)
+			client.SetLogger(NopLogger)
+			client.SetCompression(CompressionConfig{Methods: tC.client})
+			conn, err := client.Dial(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload := bytes.Repeat([]byte("compress me "), 10000)
+			if err := conn.WriteMessage(payload); err != nil {
+				t.Fatal(err)
+			}
+			msg, err := conn.ReadMessage()
+			if err != nil || !bytes.Equal(msg, payload) {
+				t.Fatalf("Expected echoed payload, got %d bytes (%v)", len(msg), err)
+			}
+
+			stats := conn.CompressionStats()
+			if stats.Method != tC.method {
+				t.Fatalf("Expected method %s, got %s", tC.method, stats.Method)
+			}
+			if tC.method != CompressionNone && (stats.Saved() <= 0 || stats.WireBytesWritten >= stats.BytesWritten) {
+				t.Fatalf("Expected compression to save bytes, got %+v", stats)
+			}
+
+			conn.Close()
+			if err := <-readErr; err != io.EOF {
+				t.Fatalf("Expected io.EOF after the client closed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressionHandshakeMismatch(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go remote.Write([]byte("HTTP/1.1"))
+	go remote.Read(make([]byte, 16))
+
+	conn := NewConn(local, 0, 1024)
+	defer conn.Close()
+	err := conn.negotiateCompression(context.Background(), CompressionConfig{Methods: []Compression{CompressionFlate}}, true)
+	if err != ErrCompressionHandshake {
+		t.Fatalf("Expected ErrCompressionHandshake, got %v", err)
+	}
+}
+
+func TestCompressionSkippedOnUnixPacket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket sockets are only tested on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "sc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sc.sock")
+
+	config := CompressionConfig{Methods: []Compression{CompressionFlate}}
+	server := NewUnixPacketServer(path, 0600, time.Second, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetCompression(config)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.WriteMessage(msg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	client := NewUnixPacketClient(path, time.Second, 1024)
+	client.SetCompression(config)
+	conn, err := client.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := conn.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("Expected hello, got %q (%v)", msg, err)
+	}
+	if method := conn.CompressionStats().Method; method != CompressionNone {
+		t.Fatalf("Expected no compression, got %s", method)
+	}
+}
+
+func TestCompressedCloseFlushes(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		method Compression
+	}{
+		{desc: "flate", method: CompressionFlate},
+		{desc: "gzip", method: CompressionGzip},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			local, remote := net.Pipe()
+			writer := newCompressedConn(local, tC.method, 0)
+			reader := newCompressedConn(remote, tC.method, 0)
+			defer reader.Close()
+
+			//Close has to send the data that was never flushed.
+			go func() {
+				writer.Write([]byte("unflushed"))
+				writer.Close()
+			}()
+			b, err := ioutil.ReadAll(reader)
+			if err != nil || string(b) != "unflushed" {
+				t.Fatalf("Expected unflushed, got %q (%v)", b, err)
+			}
+		})
+		t.Run(tC.desc+" truncated", func(t *testing.T) {
+			local, remote := net.Pipe()
+			writer := newCompressedConn(local, tC.method, 0)
+			reader := newCompressedConn(remote, tC.method, 0)
+			defer reader.Close()
+
+			//The peer dies without ending the stream.
+			go func() {
+				writer.Write([]byte("partial"))
+				writer.Flush()
+				local.Close()
+			}()
+			_, err := ioutil.ReadAll(reader)
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+			}
+		})
+	}
+}
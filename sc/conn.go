@@ -1,32 +1,452 @@
 package sc
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //Conn wraps net.Conn and implements timeouts and limited reading of conn.
 type Conn struct {
 	net.Conn
+
+	//id is assigned by the server, start is the time the connection was established.
+	id    uint64
+	start time.Time
+	//timeout is the idle timeout, readTimeout and writeTimeout bound single reads and writes.
+	//They are accessed atomically.
 	timeout       time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
 	maxReadBuffer int64
+
+	//bytesRead and bytesWritten count the transferred bytes. They are accessed atomically.
+	bytesRead    int64
+	bytesWritten int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	//framer splits the stream into messages for ReadMessage and WriteMessage. It is guarded by framerMu,
+	//since the heartbeat writes with it while handlers may replace it.
+	framerMu sync.RWMutex
+	framer   Framer
+	//reader buffers reads once ReadMessage has been called. All reads go through it from then on.
+	reader *bufio.Reader
+	//writeMu serializes WriteMessage calls.
+	writeMu sync.Mutex
+
+	//tlsState is set after a successful TLS handshake and nil for plain text connections.
+	tlsState *tls.ConnectionState
+
+	//proxy is set if the connection started with a PROXY protocol header.
+	proxy *proxyConn
+
+	//lastSeen is the time of the last read data in unix nanoseconds, rtt the last measured heartbeat round-trip time.
+	//heartbeat is set once StartHeartbeat was called. They are accessed atomically.
+	//pings passes the send times of received pings from ReadMessage to the heartbeat routine, which answers them.
+	lastSeen  int64
+	rtt       int64
+	heartbeat int32
+	pings     chan int64
+
+	//logger receives the events of the connection. opened is set once the open event was emitted,
+	//so only opened connections emit a close event. It is accessed atomically.
+	logger Logger
+	opened int32
+}
+
+//Timeout is the getter of type Conn.timeout, the idle timeout of the connection.
+func (c *Conn) Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.timeout)))
+}
+
+//SetIdleTimeout overrides the idle timeout of the connection.
+//Every Read and Write fails with a *TimeoutError if the connection has been idle for longer than timeout.
+//A timeout of 0 disables the idle timeout.
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	atomic.StoreInt64((*int64)(&c.timeout), int64(timeout))
+}
+
+//ReadTimeout is the getter of type Conn.readTimeout.
+func (c *Conn) ReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.readTimeout)))
 }
 
-//Timeout is the getter of type Conn.timeout
-func (c Conn) Timeout() time.Duration {
-	return c.timeout
+//SetReadTimeout overrides the maximum time a single Read may block. A timeout of 0 disables the read timeout.
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64((*int64)(&c.readTimeout), int64(timeout))
 }
 
-//NewConn is the constructor for the Conn struct. The timeout of conn has to be set manually.
-//The default 0 means no timeouts. The handle function for Conn has to use net.Conn.SetDeadline for timeouts.
+//WriteTimeout is the getter of type Conn.writeTimeout.
+func (c *Conn) WriteTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.writeTimeout)))
+}
+
+//SetWriteTimeout overrides the maximum time a single Write may block. A timeout of 0 disables the write timeout.
+func (c *Conn) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64((*int64)(&c.writeTimeout), int64(timeout))
+}
+
+//NewConn is the constructor for the Conn struct. timeout is the idle timeout of the connection.
+//The default 0 means no timeouts. Read and write timeouts can be set with SetReadTimeout and SetWriteTimeout.
+//The deadlines are applied on every Read and Write, a timed out operation returns a *TimeoutError,
+//which matches ErrTimeout with errors.Is. Deadlines set with net.Conn.SetDeadline are overwritten as long as a timeout is set.
 func NewConn(conn net.Conn, timeout time.Duration, maxReadBuffer int64) *Conn {
-	return &Conn{conn, timeout, maxReadBuffer}
+	return newConnContext(context.Background(), conn, timeout, maxReadBuffer)
+}
+
+//newConnContext constructs a Conn whose context is derived from parent.
+func newConnContext(parent context.Context, conn net.Conn, timeout time.Duration, maxReadBuffer int64) *Conn {
+	ctx, cancel := context.WithCancel(parent)
+	start := time.Now()
+	return &Conn{Conn: conn,
+		start:         start,
+		lastSeen:      start.UnixNano(),
+		timeout:       timeout,
+		maxReadBuffer: maxReadBuffer,
+		framer:        LengthPrefixFramer{},
+		pings:         make(chan int64, 1),
+		logger:        defaultLogger,
+		ctx:           ctx,
+		cancel:        cancel}
+}
+
+//ID returns the id the server assigned to the connection. Ids are unique per server and start at 1.
+//Connections that were not accepted by a server have the id 0.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+//StartTime returns the time the connection was established.
+func (c *Conn) StartTime() time.Time {
+	return c.start
+}
+
+//Info returns a snapshot of the connection for listings.
+func (c *Conn) Info() ConnInfo {
+	return ConnInfo{ID: c.id,
+		RemoteAddr:   c.RemoteAddr(),
+		LocalAddr:    c.LocalAddr(),
+		Start:        c.start,
+		BytesRead:    c.BytesRead(),
+		BytesWritten: c.BytesWritten(),
+		LastSeen:     c.LastSeen(),
+		RTT:          c.RTT()}
+}
+
+//Context returns the context of the connection.
+//For connections accepted by a Server the context is cancelled when the server shuts down
+//or when the handle function returns. Long running handle functions should watch ctx.Done().
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+//Close cancels the context of the connection and closes the underlying net.Conn.
+//Compressed connections send unflushed data and the end of the compression stream first.
+func (c *Conn) Close() error {
+	c.cancel()
+	err := c.Conn.Close()
+	if atomic.CompareAndSwapInt32(&c.opened, 1, 0) {
+		c.log(Event{Kind: EventConnClosed, Duration: time.Since(c.start), Err: err})
+	}
+	return err
+}
+
+//SetLogger sets the logger receiving the events of the connection.
+//Connections of a Server or Client use the logger of the Server or Client.
+func (c *Conn) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+//markOpened emits the open event of the connection.
+func (c *Conn) markOpened() {
+	atomic.StoreInt32(&c.opened, 1)
+	c.log(Event{Kind: EventConnOpened})
+}
+
+//Read reads from the connection. Data that was buffered by ReadMessage is returned first.
+//The read and idle timeout of the connection are applied.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.reader != nil {
+		return c.reader.Read(b)
+	}
+	return c.rawRead(b)
+}
+
+//Write writes to the connection. The write and idle timeout of the connection are applied.
+func (c *Conn) Write(b []byte) (int, error) {
+	deadline, ok := c.deadline(c.WriteTimeout())
+	if ok {
+		err := c.Conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesWritten, int64(n))
+	return n, wrapTimeout("write", err)
+}
+
+//BytesRead returns the amount of bytes read from the connection.
+func (c *Conn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+//BytesWritten returns the amount of bytes written to the connection.
+func (c *Conn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
+
+//rawRead reads from the underlying net.Conn after applying the read deadline.
+func (c *Conn) rawRead(b []byte) (int, error) {
+	deadline, ok := c.deadline(c.ReadTimeout())
+	if ok {
+		err := c.Conn.SetReadDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddInt64(&c.bytesRead, int64(n))
+		atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+	}
+	return n, wrapTimeout("read", err)
+}
+
+//deadline returns the earlier deadline of timeout and the idle timeout, starting now.
+//The bool is false if neither timeout is set.
+func (c *Conn) deadline(timeout time.Duration) (time.Time, bool) {
+	idleTimeout := c.Timeout()
+	if idleTimeout > 0 && (timeout <= 0 || idleTimeout < timeout) {
+		timeout = idleTimeout
+	}
+	if timeout <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(timeout), true
+}
+
+//rawReader reads from a Conn bypassing its read buffer.
+type rawReader struct {
+	c *Conn
+}
+
+func (r rawReader) Read(b []byte) (int, error) {
+	return r.c.rawRead(b)
 }
 
 //LimitedRead wraps the standard call to Read in a LimitReader.
 //Returns the the amount of bytes read, which is the lower number of len(b) and maxReadBuffer.
-func (c Conn) LimitedRead(b []byte) (int, error) {
-	r := io.LimitReader(c.Conn, c.maxReadBuffer)
+func (c *Conn) LimitedRead(b []byte) (int, error) {
+	r := io.LimitReader(c, c.maxReadBuffer)
 	return r.Read(b)
 }
+
+//SetFramer sets the Framer used by ReadMessage and WriteMessage. The default is LengthPrefixFramer.
+//Both ends of a connection have to use the same Framer.
+func (c *Conn) SetFramer(framer Framer) {
+	c.framerMu.Lock()
+	defer c.framerMu.Unlock()
+	c.framer = framer
+}
+
+func (c *Conn) currentFramer() Framer {
+	c.framerMu.RLock()
+	defer c.framerMu.RUnlock()
+	return c.framer
+}
+
+//ReadMessage reads the next framed message from the connection.
+//The maxReadBuffer of the connection is the maximum size of a message, a value <= 0 means no limit.
+//Larger messages are rejected with a *MessageTooLargeError, which matches ErrMessageTooLarge.
+//On connections with heartbeats, pings and pongs are handed to the heartbeat and not returned, see StartHeartbeat.
+//ReadMessage must not be called concurrently.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	if c.reader == nil {
+		size := 4096
+		if messageOriented(c.Conn) {
+			//A read has to take the whole datagram, the rest of it would be discarded.
+			size = maxDatagramSize
+		}
+		c.reader = bufio.NewReaderSize(rawReader{c}, size)
+	}
+	for {
+		msg, err := c.currentFramer().ReadFrame(c.reader, c.maxReadBuffer)
+		if err != nil {
+			return nil, err
+		}
+		if atomic.LoadInt32(&c.heartbeat) == 0 {
+			return msg, nil
+		}
+
+		msg, ok, err := c.openEnvelope(msg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return msg, nil
+		}
+	}
+}
+
+//messageOriented reports whether conn preserves message boundaries, like udp sessions and unixpacket sockets.
+func messageOriented(conn net.Conn) bool {
+	if _, ok := conn.(*packetConn); ok {
+		return true
+	}
+	addr := conn.LocalAddr()
+	return addr != nil && addr.Network() == "unixpacket"
+}
+
+//WriteMessage writes msg as a single framed message to the connection and flushes it on compressed connections.
+//WriteMessage is safe for concurrent use.
+func (c *Conn) WriteMessage(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.writeMessage(msg)
+}
+
+//writeMessageWithin writes msg like WriteMessage, but closes the connection if the write takes longer than timeout.
+//The write deadline is left alone, it belongs to the writes of the user.
+func (c *Conn) writeMessageWithin(msg []byte, timeout time.Duration) error {
+	var expired int32
+	//The timer is started before locking, a stuck write of someone else counts as well.
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		c.Close()
+	})
+	defer timer.Stop()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	err := c.writeMessage(msg)
+	if err != nil && atomic.LoadInt32(&expired) == 1 {
+		return &TimeoutError{Op: "write", Err: err}
+	}
+	return err
+}
+
+//writeMessage frames and writes msg. c.writeMu has to be held.
+func (c *Conn) writeMessage(msg []byte) error {
+	if atomic.LoadInt32(&c.heartbeat) == 1 {
+		enveloped := make([]byte, 1+len(msg))
+		enveloped[0] = envelopeMessage
+		copy(enveloped[1:], msg)
+		msg = enveloped
+	}
+	err := c.currentFramer().WriteFrame(c, msg)
+	if err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+//handshake runs the TLS handshake if the underlying net.Conn is a *tls.Conn and stores the negotiated state.
+//The handshake is bound by the timeout of c and by ctx. Plain text connections are left untouched.
+func (c *Conn) handshake(ctx context.Context) error {
+	tlsConn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	release, err := c.bindToContext(ctx, tlsConn)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = tlsConn.Handshake()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	state := tlsConn.ConnectionState()
+	c.tlsState = &state
+	return nil
+}
+
+//bindToContext bounds the I/O on conn by the deadline of ctx and the idle timeout of c
+//and aborts blocked I/O once ctx is cancelled by moving the deadline into the past.
+//The returned func resets the deadline and has to be called once the handshake on conn is done.
+func (c *Conn) bindToContext(ctx context.Context, conn net.Conn) (func(), error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if timeout := c.Timeout(); timeout > 0 && (!hasDeadline || time.Now().Add(timeout).Before(deadline)) {
+		deadline, hasDeadline = time.Now().Add(timeout), true
+	}
+	if hasDeadline {
+		err := conn.SetDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+		conn.SetDeadline(time.Time{})
+	}, nil
+}
+
+//ConnectionState returns the negotiated TLS state of the connection.
+//The returned bool is false if the connection is not a TLS connection.
+func (c *Conn) ConnectionState() (tls.ConnectionState, bool) {
+	if c.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *c.tlsState, true
+}
+
+//PeerCertificate returns the leaf certificate presented by the peer.
+//Returns nil if the connection is not a TLS connection or the peer did not present a certificate.
+func (c *Conn) PeerCertificate() *x509.Certificate {
+	if c.tlsState == nil || len(c.tlsState.PeerCertificates) == 0 {
+		return nil
+	}
+	return c.tlsState.PeerCertificates[0]
+}
+
+//CipherSuite returns the negotiated TLS cipher suite. Returns 0 for plain text connections.
+//Use tls.CipherSuiteName for a readable representation.
+func (c *Conn) CipherSuite() uint16 {
+	if c.tlsState == nil {
+		return 0
+	}
+	return c.tlsState.CipherSuite
+}
+
+//PeerCredentials returns the credentials of the process on the other end of a unix domain socket connection.
+//The credentials are those of the peer at the time it connected.
+//Returns ErrNoPeerCredentials for non unix connections and on platforms without SO_PEERCRED.
+func (c *Conn) PeerCredentials() (*PeerCredentials, error) {
+	unixConn, ok := unwrapConn(c.Conn).(*net.UnixConn)
+	if !ok {
+		return nil, ErrNoPeerCredentials
+	}
+	return peerCredentials(unixConn)
+}
@@ -1 +1,213 @@
-package sc
\ No newline at end of file
+package sc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+//testCA is a throwaway certificate authority for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+//issue creates a leaf certificate for commonName that is valid for 127.0.0.1.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestConnMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)}}
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)},
+		RootCAs:      ca.pool,
+	}
+
+	peers := make(chan string, 1)
+	server := NewMutualTLSServer(0, serverConfig, ca.pool, time.Second, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		if conn.CipherSuite() == 0 {
+			t.Error("Expected negotiated cipher suite")
+		}
+		if cert := conn.PeerCertificate(); cert != nil {
+			peers <- cert.Subject.CommonName
+		}
+		conn.Write([]byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	client := NewTLSClient(net.IPv4(127, 0, 0, 1), addrPort(server.Addr()), clientConfig, time.Second, 1024)
+	clientWaitGroup, errChan := client.Connect(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		if _, ok := conn.ConnectionState(); !ok {
+			t.Error("Expected TLS connection state on client")
+		}
+		if cert := conn.PeerCertificate(); cert == nil || cert.Subject.CommonName != "server" {
+			t.Errorf("Expected server certificate, got %v", cert)
+		}
+		conn.Read(make([]byte, 1))
+	})
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	clientWaitGroup.Wait()
+
+	select {
+	case name := <-peers:
+		if name != "alice" {
+			t.Fatalf("Expected peer alice, got %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Server did not see a client certificate")
+	}
+}
+
+func TestConnPlainTextHasNoTLSState(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := NewConn(a, 0, 1024)
+	defer conn.Close()
+
+	if _, ok := conn.ConnectionState(); ok {
+		t.Fatal("Expected no TLS state on plain text conn")
+	}
+	if conn.PeerCertificate() != nil || conn.CipherSuite() != 0 {
+		t.Fatal("Expected empty TLS accessors on plain text conn")
+	}
+}
+
+func TestConnTimeouts(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		setup func(*Conn)
+		op    func(*Conn) error
+	}{
+		{
+			desc:  "Read timeout",
+			setup: func(c *Conn) { c.SetReadTimeout(20 * time.Millisecond) },
+			op:    func(c *Conn) error { _, err := c.Read(make([]byte, 1)); return err },
+		},
+		{
+			desc:  "Idle timeout on read",
+			setup: func(c *Conn) { c.SetIdleTimeout(20 * time.Millisecond) },
+			op:    func(c *Conn) error { _, err := c.ReadMessage(); return err },
+		},
+		{
+			desc:  "Write timeout",
+			setup: func(c *Conn) { c.SetWriteTimeout(20 * time.Millisecond) },
+			op:    func(c *Conn) error { _, err := c.Write([]byte{1}); return err },
+		},
+	}
+
+	for _, tC := range testCases {
+		a, b := net.Pipe()
+		conn := NewConn(a, 0, 1024)
+		tC.setup(conn)
+
+		err := tC.op(conn)
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("%s: Expected ErrTimeout, got %v", tC.desc, err)
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Fatalf("%s: Expected net.Error with Timeout, got %v", tC.desc, err)
+		}
+		conn.Close()
+		b.Close()
+	}
+}
+
+func TestServerAppliesTimeouts(t *testing.T) {
+	errs := make(chan error, 1)
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetReadTimeout(20 * time.Millisecond)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		_, err := conn.Read(make([]byte, 1))
+		errs <- err
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	netConn, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("Expected ErrTimeout, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read timeout was not applied")
+	}
+}
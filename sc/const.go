@@ -3,8 +3,10 @@ package sc
 type protocol int
 
 const (
-	tcp protocol = 0
-	udp protocol = 1
+	tcp        protocol = 0
+	udp        protocol = 1
+	unix       protocol = 2
+	unixpacket protocol = 3
 )
 
 func (p protocol) String() string {
@@ -13,7 +15,26 @@ func (p protocol) String() string {
 		return "tcp"
 	case udp:
 		return "udp"
+	case unix:
+		return "unix"
+	case unixpacket:
+		return "unixpacket"
 	default:
 		return "tcp"
 	}
 }
+
+//packetBased reports whether p is served from a single packet socket instead of a listener.
+func (p protocol) packetBased() bool {
+	return p == udp
+}
+
+//messageBased reports whether p preserves message boundaries, so a single read returns a whole datagram.
+func (p protocol) messageBased() bool {
+	return p == udp || p == unixpacket
+}
+
+//unixBased reports whether p addresses a filesystem path instead of an ip and port.
+func (p protocol) unixBased() bool {
+	return p == unix || p == unixpacket
+}
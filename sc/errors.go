@@ -0,0 +1,238 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+//ErrNoPeerCredentials is returned by Conn.PeerCredentials if the peer credentials can not be determined.
+var ErrNoPeerCredentials = errors.New("Peer credentials are only available for unix domain socket connections on linux")
+
+//ErrTimeout is matched by every *TimeoutError, use errors.Is to detect timed out reads and writes of a Conn.
+var ErrTimeout = errors.New("Connection timed out")
+
+//ErrMessageTooLarge is matched by every *MessageTooLargeError, use errors.Is to detect it.
+var ErrMessageTooLarge = errors.New("Message exceeds the maximum message size")
+
+//ErrDelimiterInMessage is returned by DelimiterFramer.WriteFrame if the message contains the delimiter.
+var ErrDelimiterInMessage = errors.New("Message contains the frame delimiter")
+
+//ErrRPCClientClosed is returned by calls of an RPCClient that has been closed.
+var ErrRPCClientClosed = errors.New("RPC client is closed")
+
+//ErrPoolClosed is returned by Pool.Get if the pool has been closed.
+var ErrPoolClosed = errors.New("Pool is closed")
+
+//ErrRateLimited is returned by RateLimiter.Allow if a peer opens new connections too fast.
+var ErrRateLimited = errors.New("Connection rate limit exceeded")
+
+//ErrTooManyConns is returned by RateLimiter.Allow if a peer exceeds its maximum amount of concurrent connections.
+var ErrTooManyConns = errors.New("Too many concurrent connections")
+
+//ErrBanned is returned by RateLimiter.Allow if a peer is banned.
+var ErrBanned = errors.New("Peer is banned")
+
+//ErrDenied is returned by IPFilter.Allow if a peer is not allowed by the ip rules.
+var ErrDenied = errors.New("Peer is denied by ip rules")
+
+//ErrUnknownConn is returned by Server.Kill if there is no open connection with the passed id.
+var ErrUnknownConn = errors.New("Unknown connection")
+
+//ErrInvalidTopicPattern is returned by BrokerClient.Subscribe for malformed subscription patterns.
+var ErrInvalidTopicPattern = errors.New("Invalid topic pattern")
+
+//ErrCompressionHandshake is returned if the peer does not answer the compression handshake correctly.
+var ErrCompressionHandshake = errors.New("Compression handshake failed")
+
+//ErrInvalidProxyHeader is returned if a trusted load balancer sent a malformed PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("Invalid PROXY protocol header")
+
+//ErrHandoffUnsupported is returned by Server.Handoff if the server has no listening sockets that can be passed to another process.
+var ErrHandoffUnsupported = errors.New("Listeners can not be handed off")
+
+//ErrInvalidEnvelope is returned by ReadMessage on a connection with heartbeats if a message lacks a valid kind byte.
+var ErrInvalidEnvelope = errors.New("Message has no valid heartbeat envelope")
+
+//HandoffError is returned by Server.Handoff if the child process did not become ready.
+type HandoffError struct {
+	Err error
+}
+
+func (e *HandoffError) Error() string {
+	return "Child process did not become ready: " + e.Err.Error()
+}
+
+//Unwrap returns the underlying error.
+func (e *HandoffError) Unwrap() error {
+	return e.Err
+}
+
+//PanicError is reported to the error handler of a Server if a handler panicked.
+type PanicError struct {
+	//Value is the value passed to panic, Stack the stack trace of the panicking routine.
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Handler panicked: %v", e.Value)
+}
+
+//errClosedConn is returned by operations on a virtual connection that has been closed.
+var errClosedConn = errors.New("use of closed connection")
+
+//ShutdownError is returned by Server.Shutdown when the passed context expired before all
+//connections were drained. Killed is the amount of connections that had to be force closed.
+type ShutdownError struct {
+	Killed int
+	Err    error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("Shutdown did not drain in time, force closed %d connection(s): %s", e.Killed, e.Err)
+}
+
+//Unwrap returns the error of the context that ended the drain phase.
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+//TimeoutError is returned by Conn.Read and Conn.Write if the read, write or idle timeout of the connection passed.
+//It implements net.Error.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s on %s: %s", ErrTimeout, e.Op, e.Err)
+}
+
+//Unwrap returns the error of the underlying net.Conn.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+//Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+//Timeout is always true for TimeoutError.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+//Temporary is always true for TimeoutError.
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+//wrapTimeout wraps err in a *TimeoutError if it is a timeout error of the net package.
+func wrapTimeout(op string, err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return &TimeoutError{Op: op, Err: err}
+	}
+	return err
+}
+
+//timeoutError is returned by virtual connections when a deadline is exceeded.
+//It implements net.Error like the errors of the net package.
+type timeoutError struct{}
+
+func (e timeoutError) Error() string {
+	return "i/o timeout"
+}
+
+//Timeout is always true for timeoutError.
+func (e timeoutError) Timeout() bool {
+	return true
+}
+
+//Temporary is always true for timeoutError.
+func (e timeoutError) Temporary() bool {
+	return true
+}
+
+//MessageTooLargeError is returned when a message exceeds the maximum message size of a connection.
+//After a MessageTooLargeError on read, the position in the stream is undefined and the connection should be closed.
+type MessageTooLargeError struct {
+	//Size is the announced size of the message or -1 if it is unknown.
+	Size  int64
+	Limit int64
+}
+
+func (e *MessageTooLargeError) Error() string {
+	if e.Size < 0 {
+		return fmt.Sprintf("%s: limit is %d bytes", ErrMessageTooLarge, e.Limit)
+	}
+	return fmt.Sprintf("%s: %d bytes, limit is %d bytes", ErrMessageTooLarge, e.Size, e.Limit)
+}
+
+//Is reports whether target is ErrMessageTooLarge.
+func (e *MessageTooLargeError) Is(target error) bool {
+	return target == ErrMessageTooLarge
+}
+
+//DialError is returned by Client.Dial if a connection to the server could not be established.
+//Op is "dial", "handshake" or "compression".
+type DialError struct {
+	Op      string
+	Network string
+	Addr    string
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("Establishing a conn with [%s over %s] failed at %s: %s", e.Addr, e.Network, e.Op, e.Err)
+}
+
+//Unwrap returns the underlying error.
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+//Timeout reports whether the dial failed because the dial timeout or the deadline of the context passed.
+func (e *DialError) Timeout() bool {
+	if e.Err == context.DeadlineExceeded {
+		return true
+	}
+	netErr, ok := e.Err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+//BroadcastError is returned by Server.Broadcast if the message could not be sent to some connections.
+//Failed maps the ids of these connections to their errors.
+type BroadcastError struct {
+	Failed map[uint64]error
+}
+
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("Broadcast failed for %d connection(s)", len(e.Failed))
+}
+
+//RemoteError is returned by RPCClient.Call if the called method returned an error on the server.
+type RemoteError struct {
+	Method  string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("RPC %s failed: %s", e.Method, e.Message)
+}
+
+//RPCConnError is returned by RPCClient.Call if the connection of the client failed.
+type RPCConnError struct {
+	Err error
+}
+
+func (e *RPCConnError) Error() string {
+	return fmt.Sprintf("RPC connection failed: %s", e.Err)
+}
+
+//Unwrap returns the error of the connection.
+func (e *RPCConnError) Unwrap() error {
+	return e.Err
+}
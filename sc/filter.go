@@ -0,0 +1,75 @@
+package sc
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+//ConnFilter decides whether a new connection is served.
+//Filters are evaluated in the order they were added before the TLS handshake and the handler of the server.
+//A ConnFilter has to be safe for concurrent use.
+type ConnFilter interface {
+	//Allow is called for every new connection with its remote address. A non nil error rejects the connection.
+	Allow(addr net.Addr) error
+
+	//Release is called when a connection that was allowed by the filter is closed.
+	Release(addr net.Addr)
+}
+
+//AddFilter appends filters to the connection filters of the server. Has to be called before Start.
+func (server *Server) AddFilter(filters ...ConnFilter) {
+	server.filters = append(server.filters, filters...)
+}
+
+//Filtered returns the amount of connections that were rejected by a ConnFilter.
+func (server *Server) Filtered() int64 {
+	return atomic.LoadInt64(&server.filtered)
+}
+
+//filter runs all filters of the server for conn.
+//The returned func releases conn from the filters that allowed it and has to be called once conn is done.
+func (server *Server) filter(conn *Conn) (func(), error) {
+	addr := conn.RemoteAddr()
+
+	for i, filter := range server.filters {
+		err := filter.Allow(addr)
+		if err != nil {
+			atomic.AddInt64(&server.filtered, 1)
+			conn.log(Event{Kind: EventConnRejected, Err: err})
+			server.release(addr, i)
+			return nil, err
+		}
+	}
+
+	return func() {
+		server.release(addr, len(server.filters))
+	}, nil
+}
+
+//release releases addr from the first n filters.
+func (server *Server) release(addr net.Addr, n int) {
+	for _, filter := range server.filters[:n] {
+		filter.Release(addr)
+	}
+}
+
+//addrIP extracts the ip of addr. Returns nil if addr has no ip, for example for unix domain sockets.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
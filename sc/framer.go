@@ -0,0 +1,162 @@
+package sc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+//Framer splits the byte stream of a connection into messages.
+//A Framer has to be safe for concurrent use, the framers of this package are stateless.
+type Framer interface {
+	//ReadFrame reads the next message from r. limit is the maximum size of a message,
+	//a limit <= 0 means no limit. Larger messages are rejected with ErrMessageTooLarge.
+	ReadFrame(r *bufio.Reader, limit int64) ([]byte, error)
+
+	//WriteFrame writes msg including its framing to w with a single call to w.Write.
+	WriteFrame(w io.Writer, msg []byte) error
+}
+
+//LengthPrefixFramer prefixes every message with its length as big endian uint32.
+type LengthPrefixFramer struct{}
+
+//ReadFrame implements Framer.
+func (f LengthPrefixFramer) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	var prefix [4]byte
+	_, err := io.ReadFull(r, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(prefix[:]))
+	return readFramePayload(r, size, limit)
+}
+
+//WriteFrame implements Framer.
+func (f LengthPrefixFramer) WriteFrame(w io.Writer, msg []byte) error {
+	if int64(len(msg)) > math.MaxUint32 {
+		return &MessageTooLargeError{Size: int64(len(msg)), Limit: math.MaxUint32}
+	}
+
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+//VarintFramer prefixes every message with its length as unsigned varint, see encoding/binary.
+type VarintFramer struct{}
+
+//ReadFrame implements Framer.
+func (f VarintFramer) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > math.MaxInt64 {
+		return nil, &MessageTooLargeError{Size: -1, Limit: limit}
+	}
+	return readFramePayload(r, int64(size), limit)
+}
+
+//WriteFrame implements Framer.
+func (f VarintFramer) WriteFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, binary.MaxVarintLen64+len(msg))
+	n := binary.PutUvarint(frame, uint64(len(msg)))
+	n += copy(frame[n:], msg)
+
+	_, err := w.Write(frame[:n])
+	return err
+}
+
+//DelimiterFramer terminates every message with Delimiter. Messages must not contain the delimiter.
+//The delimiter is not part of the messages returned by ReadFrame.
+type DelimiterFramer struct {
+	Delimiter byte
+}
+
+//NewlineFramer returns a DelimiterFramer for line based protocols.
+func NewlineFramer() DelimiterFramer {
+	return DelimiterFramer{Delimiter: '\n'}
+}
+
+//ReadFrame implements Framer.
+func (f DelimiterFramer) ReadFrame(r *bufio.Reader, limit int64) ([]byte, error) {
+	var msg []byte
+	for {
+		chunk, err := r.ReadSlice(f.Delimiter)
+		if limit > 0 && int64(len(msg)+len(chunk)) > limit+1 {
+			return nil, &MessageTooLargeError{Size: -1, Limit: limit}
+		}
+		msg = append(msg, chunk...)
+
+		switch err {
+		case nil:
+			return msg[:len(msg)-1], nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(msg) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, io.EOF
+		default:
+			return nil, err
+		}
+	}
+}
+
+//WriteFrame implements Framer.
+func (f DelimiterFramer) WriteFrame(w io.Writer, msg []byte) error {
+	if bytes.IndexByte(msg, f.Delimiter) >= 0 {
+		return ErrDelimiterInMessage
+	}
+
+	frame := make([]byte, len(msg)+1)
+	copy(frame, msg)
+	frame[len(msg)] = f.Delimiter
+
+	_, err := w.Write(frame)
+	return err
+}
+
+//preallocLimit is the largest announced payload size which is allocated before reading it.
+//Larger payloads are only buffered as they arrive, so a bogus size from the peer can not exhaust the memory.
+const preallocLimit = 1 << 20
+
+//readFramePayload reads a payload of size bytes after checking it against limit.
+func readFramePayload(r io.Reader, size, limit int64) ([]byte, error) {
+	if limit > 0 && size > limit {
+		return nil, &MessageTooLargeError{Size: size, Limit: limit}
+	}
+	if int64(int(size)) != size {
+		//Does not fit into a slice on this platform.
+		return nil, &MessageTooLargeError{Size: size, Limit: limit}
+	}
+
+	if size > preallocLimit {
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, r, size)
+		if n < size && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	msg := make([]byte, size)
+	_, err := io.ReadFull(r, msg)
+	if err == io.EOF && size > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
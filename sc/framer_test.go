@@ -0,0 +1,104 @@
+package sc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFramers(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		framer Framer
+	}{
+		{desc: "Length prefix", framer: LengthPrefixFramer{}},
+		{desc: "Varint", framer: VarintFramer{}},
+		{desc: "Newline", framer: NewlineFramer()},
+	}
+
+	messages := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("a"), 5000), []byte("bye")}
+
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			a, b := net.Pipe()
+			writer := NewConn(a, 0, 8192)
+			reader := NewConn(b, 0, 8192)
+			writer.SetFramer(tC.framer)
+			reader.SetFramer(tC.framer)
+			defer writer.Close()
+			defer reader.Close()
+
+			go func() {
+				for _, msg := range messages {
+					if err := writer.WriteMessage(msg); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+
+			for _, expected := range messages {
+				msg, err := reader.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(msg, expected) {
+					t.Fatalf("Expected message of len %d, got len %d", len(expected), len(msg))
+				}
+			}
+		})
+	}
+}
+
+func TestFramersMessageTooLarge(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		framer Framer
+	}{
+		{desc: "Length prefix", framer: LengthPrefixFramer{}},
+		{desc: "Varint", framer: VarintFramer{}},
+		{desc: "Newline", framer: NewlineFramer()},
+	}
+
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			a, b := net.Pipe()
+			writer := NewConn(a, 0, 0)
+			reader := NewConn(b, 0, 16)
+			writer.SetFramer(tC.framer)
+			reader.SetFramer(tC.framer)
+			defer writer.Close()
+			defer reader.Close()
+
+			go writer.WriteMessage(bytes.Repeat([]byte("a"), 17))
+
+			_, err := reader.ReadMessage()
+			if !errors.Is(err, ErrMessageTooLarge) {
+				t.Fatalf("Expected ErrMessageTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDelimiterFramerRejectsDelimiter(t *testing.T) {
+	err := NewlineFramer().WriteFrame(&bytes.Buffer{}, []byte("a\nb"))
+	if err != ErrDelimiterInMessage {
+		t.Fatalf("Expected ErrDelimiterInMessage, got %v", err)
+	}
+}
+
+func TestVarintFramerHugeSizeWithoutLimit(t *testing.T) {
+	frame := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(frame, 1<<62)
+	r := bufio.NewReader(bytes.NewReader(append(frame[:n], "short"...)))
+
+	_, err := VarintFramer{}.ReadFrame(r, 0)
+	if err != io.ErrUnexpectedEOF && !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Expected ErrUnexpectedEOF or ErrMessageTooLarge, got %v", err)
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/beeemT/Packages/sc
 
-go 1.12
+go 1.13
 
 require github.com/beeemT/Packages/netutil v1.1.0
@@ -0,0 +1,42 @@
+package sc
+
+import (
+	"context"
+)
+
+//Handler serves a single connection of a Server.
+//ctx is the context of conn, it is cancelled when the server shuts down.
+//The Handler has to close conn itself, except for udp sessions which are closed when ServeConn returns.
+//A returned error is counted by the server and passed to its error handler, see Server.SetErrorHandler.
+//A panic in ServeConn is recovered by the server, which closes conn and reports a *PanicError the same way.
+type Handler interface {
+	ServeConn(ctx context.Context, conn *Conn) error
+}
+
+//HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, conn *Conn) error
+
+//ServeConn calls f(ctx, conn).
+func (f HandlerFunc) ServeConn(ctx context.Context, conn *Conn) error {
+	return f(ctx, conn)
+}
+
+//Middleware wraps a Handler to run code before and after it, for example for logging, auth or metrics.
+type Middleware func(next Handler) Handler
+
+//Chain wraps handler in middleware. The first middleware is the outermost one,
+//so Chain(h, a, b) serves a connection with a(b(h)).
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+//handleFunc adapts the handle functions of Server.Start to the Handler interface.
+func handleFunc(handle func(*Conn, ...interface{}), a ...interface{}) Handler {
+	return HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		handle(conn, a...)
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, conn *Conn) error {
+				order = append(order, name+" before")
+				err := next.ServeConn(ctx, conn)
+				order = append(order, name+" after")
+				return err
+			})
+		}
+	}
+
+	handler := Chain(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		order = append(order, "handler")
+		return nil
+	}), record("a"), record("b"))
+	handler.ServeConn(context.Background(), nil)
+
+	expected := "a before,b before,handler,b after,a after"
+	if got := strings.Join(order, ","); got != expected {
+		t.Fatalf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	var mu sync.Mutex
+	var served []string
+
+	//deny closes every connection that does not start with the password.
+	deny := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, conn *Conn) error {
+			b := make([]byte, 6)
+			if _, err := conn.Read(b); err != nil || string(b) != "secret" {
+				conn.Close()
+				return nil
+			}
+			return next.ServeConn(ctx, conn)
+		})
+	}
+
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.Use(deny)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		mu.Lock()
+		served = append(served, conn.RemoteAddr().String())
+		mu.Unlock()
+		conn.Write([]byte("ok"))
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	for _, password := range []string{"wrong!", "secret"} {
+		netConn, err := net.Dial("tcp", loopback(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		netConn.Write([]byte(password))
+		netConn.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 2)
+		n, _ := netConn.Read(b)
+		netConn.Close()
+
+		if (password == "secret") != (string(b[:n]) == "ok") {
+			t.Fatalf("Unexpected response %q for password %s", b[:n], password)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(served) != 1 {
+		t.Fatalf("Expected one served connection, got %d", len(served))
+	}
+}
+
+func TestServerHandlerErrors(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	reported := make(chan error, 2)
+
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	server.SetErrorHandler(func(conn *Conn, err error) {
+		reported <- err
+	})
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		if string(b) == "panic" {
+			panic("boom")
+		}
+		return errHandler
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	for _, msg := range []string{"panic", "error"} {
+		netConn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		netConn.Write([]byte(msg))
+		//The connection is closed by the server in both cases.
+		if _, err := netConn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%s: Expected closed connection, got %v", msg, err)
+		}
+		netConn.Close()
+	}
+
+	var panicErr *PanicError
+	if err := <-reported; !errors.As(err, &panicErr) || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("Expected *PanicError with stack, got %v", err)
+	}
+	if err := <-reported; err != errHandler {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+
+	waitForClients(t, server, 0)
+	metrics := server.Metrics()
+	if metrics.HandlerPanics != 1 || metrics.HandlerErrors != 1 {
+		t.Fatalf("Expected 1 panic and 1 error, got %+v", metrics)
+	}
+}
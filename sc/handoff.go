@@ -0,0 +1,181 @@
+// +build !windows
+
+package sc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	//HandoffListenFDsEnv advertises the file descriptors of the inherited listeners to the child process
+	//as a comma separated list, for example "3,4".
+	HandoffListenFDsEnv = "SC_LISTEN_FDS"
+	//HandoffReadyFDEnv advertises the file descriptor the child process signals its readiness on.
+	HandoffReadyFDEnv = "SC_READY_FD"
+)
+
+//fileListener is implemented by *net.TCPListener and *net.UnixListener.
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+//Handoff passes the listening sockets of the server to a new process for a restart without downtime.
+//cmd is started with the sockets as inherited file descriptors, see InheritedListeners.
+//If cmd is nil, the running executable is started again with the same arguments.
+//Handoff returns once the child process started its server, which it signals automatically on Start.
+//The server then stops accepting connections and the caller should drain the open ones with Shutdown before exiting.
+//If ctx is done before the child is ready, the child is killed and the server keeps accepting connections.
+//Only stream based servers started on their own addresses or inherited listeners can hand off their sockets.
+func (server *Server) Handoff(ctx context.Context, cmd *exec.Cmd) error {
+	server.connsMu.Lock()
+	sockets := server.sockets
+	server.connsMu.Unlock()
+	if len(sockets) == 0 {
+		return ErrHandoffUnsupported
+	}
+
+	files := make([]*os.File, 0, len(sockets))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, socket := range sockets {
+		listener, ok := socket.(fileListener)
+		if !ok {
+			return ErrHandoffUnsupported
+		}
+		file, err := listener.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	if cmd == nil {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command(executable, os.Args[1:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	//ExtraFiles[i] becomes file descriptor 3+i in the child.
+	fds := make([]string, 0, len(files))
+	for i := range files {
+		fds = append(fds, strconv.Itoa(3+len(cmd.ExtraFiles)+i))
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	readyFD := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, readyWriter)
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		HandoffListenFDsEnv+"="+strings.Join(fds, ","),
+		HandoffReadyFDEnv+"="+strconv.Itoa(readyFD))
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	//The child writes a byte once it is ready. It closes the pipe without writing if it exits before.
+	readyErr := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(ready, make([]byte, 1))
+		readyErr <- err
+	}()
+
+	select {
+	case err = <-readyErr:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return &HandoffError{Err: err}
+	}
+
+	//Stop accepting before Stop, so no connection is accepted and dropped in between.
+	//Closing a unix listener removes its socket file, which the child still listens on.
+	atomic.StoreInt32(&server.handedOff, 1)
+	for _, socket := range sockets {
+		if unixListener, ok := socket.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+		socket.Close()
+	}
+	server.log(Event{Kind: EventServerStopping, Message: fmt.Sprintf("handed off to process %d", cmd.Process.Pid)})
+	server.Stop()
+	return nil
+}
+
+//InheritedListeners returns the listeners passed by the Handoff of the parent process.
+//It returns no listeners if the process was not started by Handoff. Pass them to Server.SetListeners before Start,
+//Start then signals the parent that the new server is ready.
+func InheritedListeners() ([]net.Listener, error) {
+	value := os.Getenv(HandoffListenFDsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	//Grandchildren must not inherit the advertisement.
+	os.Unsetenv(HandoffListenFDsEnv)
+
+	var listeners []net.Listener
+	for _, field := range strings.Split(value, ",") {
+		fd, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s %q: %s", HandoffListenFDsEnv, value, err)
+		}
+
+		file := os.NewFile(uintptr(fd), "sc-listener-"+field)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+//notifyHandoffReady signals the parent process of a Handoff that the server of this process is ready.
+//It is a no-op if the process was not started by Handoff or already signalled.
+func notifyHandoffReady() error {
+	value := os.Getenv(HandoffReadyFDEnv)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(HandoffReadyFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("Invalid %s %q: %s", HandoffReadyFDEnv, value, err)
+	}
+	file := os.NewFile(uintptr(fd), "sc-ready")
+	defer file.Close()
+	_, err = file.Write([]byte{1})
+	return err
+}
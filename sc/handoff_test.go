@@ -0,0 +1,103 @@
+// +build !windows
+
+package sc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+//TestHandoffChild is the child process of TestHandoff. It serves a single connection on the inherited listener.
+func TestHandoffChild(t *testing.T) {
+	if os.Getenv("SC_HANDOFF_CHILD") != "1" {
+		t.Skip("Only run as child process of TestHandoff")
+	}
+
+	listeners, err := InheritedListeners()
+	if err != nil || len(listeners) != 1 {
+		t.Fatalf("Expected one inherited listener, got %d (%v)", len(listeners), err)
+	}
+
+	served := make(chan struct{})
+	server := NewTCPServer(0, time.Second, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetListeners(listeners...)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer close(served)
+		conn.Write([]byte("child"))
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Child did not serve a connection")
+	}
+	server.Stop()
+	wg.Wait()
+}
+
+func TestHandoff(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+
+	//The parent holds one connection open, which has to be drained after the handoff.
+	release := make(chan struct{})
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		<-release
+		conn.Write([]byte("parent"))
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addr().String()
+
+	old, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	waitForClients(t, server, 1)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$")
+	cmd.Env = append(os.Environ(), "SC_HANDOFF_CHILD=1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Handoff(ctx, cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	//New connections are served by the child.
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	if b, err := ioutil.ReadAll(netConn); err != nil || string(b) != "child" {
+		t.Fatalf("Expected the child to answer, got %q (%v)", b, err)
+	}
+
+	//The open connection of the parent is drained.
+	close(release)
+	if b, err := ioutil.ReadAll(old); err != nil || string(b) != "parent" {
+		t.Fatalf("Expected the parent to answer, got %q (%v)", b, err)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Child failed: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+package sc
+
+import (
+	"context"
+	"net"
+	"os/exec"
+)
+
+//Handoff is not supported on windows, since processes can not inherit listening sockets as file descriptors.
+func (server *Server) Handoff(ctx context.Context, cmd *exec.Cmd) error {
+	return ErrHandoffUnsupported
+}
+
+//InheritedListeners always returns no listeners on windows.
+func InheritedListeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+func notifyHandoffReady() error {
+	return nil
+}
@@ -0,0 +1,188 @@
+package sc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//defaultMissThreshold is the amount of missed heartbeat intervals after which a peer is considered dead
+//if HeartbeatConfig.MissThreshold is not set.
+const defaultMissThreshold = 3
+
+//Once heartbeats are started, every message is prefixed with a kind byte, so pings and pongs can not be
+//confused with user messages. Pings and pongs carry the send time of the ping in unix nanoseconds as 16 hex digits.
+//All of it is printable ASCII, so it passes delimiter based framers.
+const (
+	envelopeMessage byte = 'm'
+	envelopePing    byte = 'p'
+	envelopePong    byte = 'q'
+)
+
+//heartbeatSize is the size of a ping or pong message.
+const heartbeatSize = 1 + 16
+
+//heartbeatAlphabet contains every byte that can occur in a ping or pong message or as kind of a message.
+const heartbeatAlphabet = "mpq0123456789abcdef"
+
+//HeartbeatConfig configures the heartbeats of a connection.
+type HeartbeatConfig struct {
+	//Interval is the time between two pings. A value <= 0 disables heartbeats.
+	Interval time.Duration
+	//MissThreshold is the amount of intervals without any data from the peer after which the connection is closed.
+	//Defaults to 3.
+	MissThreshold int
+}
+
+//StartHeartbeat sends a ping message every config.Interval and closes the connection once nothing was received
+//from the peer for config.MissThreshold intervals. Pings are answered with pongs by the heartbeat of the peer,
+//so both ends have to start heartbeats before exchanging messages: from then on every message written with
+//WriteMessage is prefixed with a kind byte, which ReadMessage of the peer strips again.
+//Both ends have to read with ReadMessage continuously, it hands pings and pongs to the heartbeat and never returns them.
+//The heartbeat pauses while the framer of the connection is a DelimiterFramer with a delimiter out of "mpq" or
+//the lowercase hex digits, as these can not frame heartbeat messages.
+//StartHeartbeat has no effect if it was called before or config.Interval is <= 0.
+//The heartbeat stops when the connection is closed.
+func (c *Conn) StartHeartbeat(config HeartbeatConfig) {
+	if config.Interval <= 0 || !atomic.CompareAndSwapInt32(&c.heartbeat, 0, 1) {
+		return
+	}
+	if config.MissThreshold <= 0 {
+		config.MissThreshold = defaultMissThreshold
+	}
+	go c.heartbeatLoop(config)
+}
+
+//LastSeen returns the last time data was received from the peer, or the start of the connection if nothing was received yet.
+func (c *Conn) LastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastSeen))
+}
+
+//RTT returns the round-trip time measured by the last answered ping. It is 0 until the first pong was received.
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+func (c *Conn) heartbeatLoop(config HeartbeatConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	deadAfter := config.Interval * time.Duration(config.MissThreshold)
+	for {
+		var err error
+		select {
+		case <-c.ctx.Done():
+			return
+		case sent := <-c.pings:
+			err = c.writeHeartbeat(envelopePong, sent, deadAfter)
+		case now := <-ticker.C:
+			if !heartbeatFramable(c.currentFramer()) {
+				//Without pings a healthy but idle peer would look dead.
+				continue
+			}
+			if silence := now.Sub(c.LastSeen()); silence > deadAfter {
+				c.log(Event{Kind: EventDeadPeer, Duration: silence})
+				c.Close()
+				return
+			}
+			err = c.writeHeartbeat(envelopePing, now.UnixNano(), deadAfter)
+		}
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+//writeHeartbeat writes a ping or pong bound by timeout, so a peer that stopped reading can not block the heartbeat.
+//The connection is closed if the message could not be written in time. The write deadline is left alone,
+//it belongs to the writes of the user. Nothing is written if the current framer can not frame heartbeats.
+func (c *Conn) writeHeartbeat(kind byte, sent int64, timeout time.Duration) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	framer := c.currentFramer()
+	if !heartbeatFramable(framer) {
+		return nil
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		c.log(Event{Kind: EventDeadPeer, Duration: time.Since(c.LastSeen())})
+		c.Close()
+	})
+	defer timer.Stop()
+
+	err := framer.WriteFrame(c, heartbeatMessage(kind, sent))
+	if err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+//openEnvelope strips the kind byte of msg. Pings are handed to the heartbeat routine to be answered,
+//pongs update the round-trip time. Returns false for pings and pongs.
+func (c *Conn) openEnvelope(msg []byte) ([]byte, bool, error) {
+	if len(msg) == 0 {
+		return nil, false, ErrInvalidEnvelope
+	}
+
+	switch msg[0] {
+	case envelopeMessage:
+		return msg[1:], true, nil
+	case envelopePing, envelopePong:
+		sent, err := heartbeatTime(msg)
+		if err != nil {
+			return nil, false, err
+		}
+		if msg[0] == envelopePong {
+			//The timestamp is the one of our own ping, so the clocks of the peers do not matter.
+			atomic.StoreInt64(&c.rtt, time.Now().UnixNano()-sent)
+			return nil, false, nil
+		}
+
+		//A ping that arrives while the previous one is still unanswered is answered by that pong.
+		select {
+		case c.pings <- sent:
+		default:
+		}
+		return nil, false, nil
+	}
+	return nil, false, ErrInvalidEnvelope
+}
+
+func heartbeatMessage(kind byte, sent int64) []byte {
+	var nanos [8]byte
+	binary.BigEndian.PutUint64(nanos[:], uint64(sent))
+
+	msg := make([]byte, heartbeatSize)
+	msg[0] = kind
+	hex.Encode(msg[1:], nanos[:])
+	return msg
+}
+
+//heartbeatTime decodes the send time of a ping or pong message.
+func heartbeatTime(msg []byte) (int64, error) {
+	if len(msg) != heartbeatSize {
+		return 0, ErrInvalidEnvelope
+	}
+
+	var nanos [8]byte
+	_, err := hex.Decode(nanos[:], msg[1:])
+	if err != nil {
+		return 0, ErrInvalidEnvelope
+	}
+	return int64(binary.BigEndian.Uint64(nanos[:])), nil
+}
+
+//heartbeatFramable reports whether framer can frame heartbeat messages.
+func heartbeatFramable(framer Framer) bool {
+	switch f := framer.(type) {
+	case DelimiterFramer:
+		return strings.IndexByte(heartbeatAlphabet, f.Delimiter) < 0
+	case *DelimiterFramer:
+		return strings.IndexByte(heartbeatAlphabet, f.Delimiter) < 0
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package sc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		framer Framer
+	}{
+		{desc: "length prefix", framer: LengthPrefixFramer{}},
+		{desc: "newline", framer: NewlineFramer()},
+		{desc: "null delimiter", framer: DelimiterFramer{Delimiter: 0}},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			local, remote := net.Pipe()
+			a := NewConn(local, 0, 1024)
+			b := NewConn(remote, 0, 1024)
+			defer a.Close()
+			defer b.Close()
+
+			//Handlers may switch the framer while the heartbeat is running already.
+			a.StartHeartbeat(HeartbeatConfig{Interval: 10 * time.Millisecond})
+			b.StartHeartbeat(HeartbeatConfig{Interval: time.Hour})
+			a.SetFramer(tC.framer)
+			b.SetFramer(tC.framer)
+
+			received := make(chan []byte, 1)
+			go func() {
+				for {
+					msg, err := b.ReadMessage()
+					if err != nil {
+						return
+					}
+					received <- msg
+				}
+			}()
+			go func() {
+				for {
+					if _, err := a.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			deadline := time.Now().Add(time.Second)
+			for a.RTT() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("Timed out waiting for a round-trip time")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if !a.LastSeen().After(a.StartTime()) {
+				t.Fatalf("Expected last seen %s to be after the start %s", a.LastSeen(), a.StartTime())
+			}
+
+			//User messages looking like heartbeats are delivered as they are.
+			for _, payload := range []string{"hello", "p0123456789abcdef", "q0123456789abcdef", ""} {
+				if err := a.WriteMessage([]byte(payload)); err != nil {
+					t.Fatal(err)
+				}
+				if msg := <-received; string(msg) != payload {
+					t.Fatalf("Expected %q, got %q", payload, msg)
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			if isClosedChan(a.Context().Done()) {
+				t.Fatal("Expected live peer to stay connected")
+			}
+		})
+	}
+}
+
+func TestHeartbeatFramable(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		framer   Framer
+		framable bool
+	}{
+		{desc: "length prefix", framer: LengthPrefixFramer{}, framable: true},
+		{desc: "newline", framer: NewlineFramer(), framable: true},
+		{desc: "hex digit", framer: DelimiterFramer{Delimiter: 'f'}, framable: false},
+		{desc: "kind", framer: &DelimiterFramer{Delimiter: 'p'}, framable: false},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			if framable := heartbeatFramable(tC.framer); framable != tC.framable {
+				t.Fatalf("Expected framable %v, got %v", tC.framable, framable)
+			}
+		})
+	}
+}
+
+func TestHeartbeatDeadPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	a := NewConn(local, 0, 1024)
+	defer a.Close()
+	//The peer never reads, so pings are never answered.
+	defer remote.Close()
+
+	a.StartHeartbeat(HeartbeatConfig{Interval: 10 * time.Millisecond, MissThreshold: 2})
+	select {
+	case <-a.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected dead peer to be closed")
+	}
+}
+
+func TestHeartbeatDisabledPassesMessages(t *testing.T) {
+	local, remote := net.Pipe()
+	a := NewConn(local, 0, 1024)
+	b := NewConn(remote, 0, 1024)
+	defer a.Close()
+	defer b.Close()
+
+	payloads := []string{string(heartbeatMessage(envelopePing, 1)), string(heartbeatMessage(envelopePong, 1)), "next"}
+	go func() {
+		for _, payload := range payloads {
+			if err := a.WriteMessage([]byte(payload)); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, payload := range payloads {
+		msg, err := b.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != payload {
+			t.Fatalf("Expected %q, got %q", payload, msg)
+		}
+	}
+}
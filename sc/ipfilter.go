@@ -0,0 +1,123 @@
+package sc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/beeemT/Packages/netutil"
+)
+
+//IPFilter is a ConnFilter with allow and deny rules in CIDR notation for IPv4 and IPv6.
+//Deny rules take precedence over allow rules. If there are allow rules, only matching peers are allowed.
+//Connections without an ip, like unix domain socket connections, are always allowed.
+//The rules can be reloaded at runtime, new connections are evaluated against the current rules.
+type IPFilter struct {
+	mu    sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+//NewIPFilter is the constructor for an IPFilter. Rules are CIDR ranges like "10.0.0.0/8" or "fe80::/10",
+//or single addresses like "127.0.0.1". Add it to a server with Server.AddFilter.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	err := filter.Reload(allow, deny)
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+//Reload replaces all rules of the filter. If a rule is invalid, the old rules are kept and an error is returned.
+func (filter *IPFilter) Reload(allow, deny []string) error {
+	allowNets, err := parseIPRules(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseIPRules(deny)
+	if err != nil {
+		return err
+	}
+
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	filter.allow = allowNets
+	filter.deny = denyNets
+	return nil
+}
+
+//Rules returns the current allow and deny rules in CIDR notation.
+func (filter *IPFilter) Rules() (allow, deny []string) {
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+	return ipNetStrings(filter.allow), ipNetStrings(filter.deny)
+}
+
+//Allow implements ConnFilter.
+func (filter *IPFilter) Allow(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	filter.mu.RLock()
+	defer filter.mu.RUnlock()
+
+	if containsIP(filter.deny, ip) {
+		return ErrDenied
+	}
+	if len(filter.allow) > 0 && !containsIP(filter.allow, ip) {
+		return ErrDenied
+	}
+	return nil
+}
+
+//Release implements ConnFilter. IPFilter does not track connections.
+func (filter *IPFilter) Release(addr net.Addr) {}
+
+//parseIPRules parses CIDR ranges and single addresses.
+func parseIPRules(rules []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+
+		if strings.Contains(rule, "/") {
+			_, ipNet, err := net.ParseCIDR(rule)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ip rule %q: %s", rule, err)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip, err := netutil.IP(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ip rule %q: %s", rule, err)
+		}
+		if netutil.IsIPv4(ip) {
+			nets = append(nets, &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)})
+		} else {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)})
+		}
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipNetStrings(nets []*net.IPNet) []string {
+	list := make([]string, len(nets))
+	for i, ipNet := range nets {
+		list[i] = ipNet.String()
+	}
+	return list
+}
@@ -0,0 +1,58 @@
+package sc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	filter, err := NewIPFilter([]string{"10.0.0.0/8", "fe80::/10", "192.168.1.1"}, []string{"10.1.0.0/16", "fe80::bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc     string
+		addr     net.Addr
+		expected error
+	}{
+		{desc: "Allowed v4 range", addr: tcpAddr("10.2.3.4"), expected: nil},
+		{desc: "Denied v4 range inside allowed range", addr: tcpAddr("10.1.3.4"), expected: ErrDenied},
+		{desc: "Allowed single v4 address", addr: tcpAddr("192.168.1.1"), expected: nil},
+		{desc: "v4 address not in allow list", addr: tcpAddr("192.168.1.2"), expected: ErrDenied},
+		{desc: "v4 address in v6 representation", addr: &net.UDPAddr{IP: net.ParseIP("::ffff:10.2.3.4")}, expected: nil},
+		{desc: "Allowed v6 range", addr: tcpAddr("fe80::1"), expected: nil},
+		{desc: "Denied single v6 address", addr: tcpAddr("fe80::bad"), expected: ErrDenied},
+		{desc: "Unix socket", addr: &net.UnixAddr{Name: "/tmp/sc.sock", Net: "unix"}, expected: nil},
+	}
+
+	for _, tC := range testCases {
+		if err := filter.Allow(tC.addr); err != tC.expected {
+			t.Fatalf("%s: Expected %v, got %v", tC.desc, tC.expected, err)
+		}
+	}
+}
+
+func TestIPFilterReload(t *testing.T) {
+	filter, err := NewIPFilter(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Allow(tcpAddr("127.0.0.1")); err != ErrDenied {
+		t.Fatalf("Expected ErrDenied, got %v", err)
+	}
+
+	if err := filter.Reload(nil, []string{"not a rule"}); err == nil {
+		t.Fatal("Expected invalid rule to be rejected")
+	}
+	if _, deny := filter.Rules(); len(deny) != 1 || deny[0] != "127.0.0.0/8" {
+		t.Fatalf("Expected old rules to be kept, got %v", deny)
+	}
+
+	if err := filter.Reload(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Allow(tcpAddr("127.0.0.1")); err != nil {
+		t.Fatalf("Expected reloaded filter to allow, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package sc
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+//EventKind names what happened in an Event.
+type EventKind string
+
+const (
+	EventServerStarting  EventKind = "server_starting"
+	EventServerStarted   EventKind = "server_started"
+	EventServerStopping  EventKind = "server_stopping"
+	EventServerStopped   EventKind = "server_stopped"
+	EventListenFailed    EventKind = "listen_failed"
+	EventAcceptFailed    EventKind = "accept_failed"
+	EventConnOpened      EventKind = "conn_opened"
+	EventConnClosed      EventKind = "conn_closed"
+	EventConnRejected    EventKind = "conn_rejected"
+	EventHandshakeFailed EventKind = "handshake_failed"
+	EventDialFailed      EventKind = "dial_failed"
+	EventDeadPeer        EventKind = "dead_peer"
+	EventHandlerError    EventKind = "handler_error"
+	EventHandlerPanic    EventKind = "handler_panic"
+	EventError           EventKind = "error"
+)
+
+//Event is a structured log entry emitted by servers, clients and their connections.
+//Fields that do not apply to the event are left at their zero value.
+type Event struct {
+	Kind       EventKind
+	Time       time.Time
+	ConnID     uint64
+	RemoteAddr net.Addr
+	//Duration is the lifetime of the connection for EventConnClosed and the silence of the peer for EventDeadPeer.
+	Duration time.Duration
+	Message  string
+	Err      error
+}
+
+//String formats the event as key=value pairs, leaving out unset fields.
+func (event Event) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event=%s", event.Kind)
+	if event.ConnID != 0 {
+		fmt.Fprintf(&b, " conn=%d", event.ConnID)
+	}
+	if event.RemoteAddr != nil {
+		fmt.Fprintf(&b, " remote=%s", event.RemoteAddr)
+	}
+	if event.Duration != 0 {
+		fmt.Fprintf(&b, " duration=%s", event.Duration)
+	}
+	if event.Message != "" {
+		fmt.Fprintf(&b, " msg=%q", event.Message)
+	}
+	if event.Err != nil {
+		fmt.Fprintf(&b, " err=%q", event.Err.Error())
+	}
+	return b.String()
+}
+
+//Logger receives the events of servers, clients and connections. Log may be called concurrently.
+type Logger interface {
+	Log(event Event)
+}
+
+//LoggerFunc adapts a function to the Logger interface.
+type LoggerFunc func(event Event)
+
+//Log implements Logger.
+func (f LoggerFunc) Log(event Event) {
+	f(event)
+}
+
+//NopLogger discards all events.
+var NopLogger Logger = LoggerFunc(func(Event) {})
+
+//defaultLogger writes events to the standard logger of the log package.
+var defaultLogger = NewStdLogger(nil)
+
+//NewStdLogger returns a Logger writing every event as a line to l. A nil l writes to the standard logger of the log package.
+//This is the default logger of servers and clients.
+func NewStdLogger(l *log.Logger) Logger {
+	return LoggerFunc(func(event Event) {
+		if l == nil {
+			log.Println(event.String())
+			return
+		}
+		l.Println(event.String())
+	})
+}
+
+//Logfer is implemented by printf style loggers, for example *logger.Logger of github.com/beeemT/Packages/logger.
+type Logfer interface {
+	Logf(format string, a ...interface{})
+}
+
+//NewLogfLogger returns a Logger writing every event as a line to l.
+func NewLogfLogger(l Logfer) Logger {
+	return LoggerFunc(func(event Event) {
+		l.Logf("%s %s\n", event.Time.Format(time.RFC3339Nano), event.String())
+	})
+}
+
+//log emits event on the logger of the server.
+func (server *Server) log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	server.logger.Log(event)
+}
+
+//log emits event on the logger of the connection with the id and remote address of the connection filled in.
+func (c *Conn) log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.ConnID = c.id
+	if event.RemoteAddr == nil {
+		event.RemoteAddr = c.RemoteAddr()
+	}
+	c.logger.Log(event)
+}
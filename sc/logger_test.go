@@ -0,0 +1,85 @@
+package sc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (recorder *eventRecorder) Log(event Event) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.events = append(recorder.events, event)
+}
+
+func (recorder *eventRecorder) find(kind EventKind) (Event, bool) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	for _, event := range recorder.events {
+		if event.Kind == kind {
+			return event, true
+		}
+	}
+	return Event{}, false
+}
+
+func TestServerLogger(t *testing.T) {
+	recorder := &eventRecorder{}
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(recorder)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), server.Addr().(*net.TCPAddr).Port, 0, 1024)
+	client.SetLogger(NopLogger)
+	conn, err := client.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	server.Stop()
+	wg.Wait()
+
+	for _, kind := range []EventKind{EventServerStarting, EventServerStarted, EventConnOpened, EventServerStopping, EventServerStopped} {
+		if _, ok := recorder.find(kind); !ok {
+			t.Fatalf("Expected %s event, got %+v", kind, recorder.events)
+		}
+	}
+	closed, ok := recorder.find(EventConnClosed)
+	if !ok || closed.ConnID != 1 || closed.RemoteAddr == nil || closed.Duration <= 0 {
+		t.Fatalf("Expected close event with id, remote address and duration, got %+v", closed)
+	}
+}
+
+type logfFunc func(format string, a ...interface{})
+
+func (f logfFunc) Logf(format string, a ...interface{}) {
+	f(format, a...)
+}
+
+func TestLogfLogger(t *testing.T) {
+	var line string
+	logger := NewLogfLogger(logfFunc(func(format string, a ...interface{}) {
+		line = fmt.Sprintf(format, a...)
+	}))
+
+	logger.Log(Event{Kind: EventConnClosed, Time: time.Now(), ConnID: 7, Duration: time.Second, Err: ErrTimeout})
+	if !strings.HasSuffix(line, "event=conn_closed conn=7 duration=1s err=\"Connection timed out\"\n") {
+		t.Fatalf("Unexpected log line %q", line)
+	}
+}
@@ -0,0 +1,263 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//defaultMemBufferSize is the amount of bytes a memory connection buffers per direction before writes block.
+const defaultMemBufferSize = 64 * 1024
+
+//memNetwork is the network name of memory addresses.
+const memNetwork = "mem"
+
+//memAddr is the address of a memory listener and its connections.
+type memAddr string
+
+func (addr memAddr) Network() string {
+	return memNetwork
+}
+
+func (addr memAddr) String() string {
+	return string(addr)
+}
+
+//MemListener is an in-memory net.Listener. Connections are created with Dial or DialContext
+//and exist only within the process, no port is bound. Use it with Server.SetListeners and Client.SetDialer,
+//for example to test handlers without touching the network.
+type MemListener struct {
+	addr  memAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	nextID  int
+	bufSize int
+}
+
+//NewMemListener is the constructor for a MemListener. name is returned by Addr.
+func NewMemListener(name string) *MemListener {
+	return &MemListener{addr: memAddr(name),
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+		bufSize: defaultMemBufferSize}
+}
+
+//Accept waits for the next dialed connection.
+func (listener *MemListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, errClosedConn
+	}
+}
+
+//Close closes the listener. Pending and future dials fail, connections that were accepted stay open.
+func (listener *MemListener) Close() error {
+	listener.once.Do(func() {
+		close(listener.done)
+	})
+	return nil
+}
+
+//Addr returns the address of the listener.
+func (listener *MemListener) Addr() net.Addr {
+	return listener.addr
+}
+
+//Dial connects to the listener. It blocks until the connection is accepted.
+func (listener *MemListener) Dial() (net.Conn, error) {
+	return listener.DialContext(context.Background(), memNetwork, string(listener.addr))
+}
+
+//DialContext connects to the listener like Dial, bound by ctx. network and address are ignored,
+//so DialContext can be passed to Client.SetDialer directly.
+func (listener *MemListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	listener.mu.Lock()
+	listener.nextID++
+	clientAddr := memAddr(listener.addr.String() + "-client-" + strconv.Itoa(listener.nextID))
+	listener.mu.Unlock()
+
+	client, server := newMemPipe(clientAddr, listener.addr, listener.bufSize)
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.done:
+		return nil, &net.OpError{Op: "dial", Net: memNetwork, Addr: listener.addr, Err: errClosedConn}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//memBuffer is one direction of a memory connection.
+type memBuffer struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	limit  int
+	closed bool
+
+	//readable and writable are signalled when data was written or read.
+	readable chan struct{}
+	writable chan struct{}
+}
+
+func newMemBuffer(limit int) *memBuffer {
+	return &memBuffer{limit: limit,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1)}
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+//close marks the buffer as closed and wakes all waiting readers and writers.
+func (buffer *memBuffer) close() {
+	buffer.mu.Lock()
+	buffer.closed = true
+	buffer.mu.Unlock()
+	signal(buffer.readable)
+	signal(buffer.writable)
+}
+
+//memConn is one end of a buffered in-memory connection. Writes only block once the buffer of the peer is full.
+type memConn struct {
+	local, remote memAddr
+	rx, tx        *memBuffer
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+//newMemPipe returns both ends of a buffered in-memory connection.
+func newMemPipe(clientAddr, serverAddr memAddr, bufSize int) (*memConn, *memConn) {
+	toServer, toClient := newMemBuffer(bufSize), newMemBuffer(bufSize)
+	client := &memConn{local: clientAddr, remote: serverAddr, rx: toClient, tx: toServer,
+		readDeadline: newDeadline(), writeDeadline: newDeadline(), done: make(chan struct{})}
+	server := &memConn{local: serverAddr, remote: clientAddr, rx: toServer, tx: toClient,
+		readDeadline: newDeadline(), writeDeadline: newDeadline(), done: make(chan struct{})}
+	return client, server
+}
+
+//Read reads buffered data. It returns io.EOF once the peer closed the connection and the buffer is drained.
+func (conn *memConn) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-conn.done:
+			return 0, errClosedConn
+		case <-conn.readDeadline.wait():
+			return 0, timeoutError{}
+		default:
+		}
+
+		conn.rx.mu.Lock()
+		if conn.rx.buf.Len() > 0 {
+			n, _ := conn.rx.buf.Read(b)
+			more := conn.rx.buf.Len() > 0
+			conn.rx.mu.Unlock()
+			signal(conn.rx.writable)
+			if more {
+				signal(conn.rx.readable)
+			}
+			return n, nil
+		}
+		closed := conn.rx.closed
+		conn.rx.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-conn.rx.readable:
+		case <-conn.done:
+		case <-conn.readDeadline.wait():
+		}
+	}
+}
+
+//Write writes b into the buffer of the peer and blocks while the buffer is full.
+func (conn *memConn) Write(b []byte) (int, error) {
+	written := 0
+	for {
+		select {
+		case <-conn.done:
+			return written, errClosedConn
+		case <-conn.writeDeadline.wait():
+			return written, timeoutError{}
+		default:
+		}
+
+		conn.tx.mu.Lock()
+		if conn.tx.closed {
+			conn.tx.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if space := conn.tx.limit - conn.tx.buf.Len(); space > 0 {
+			n := len(b) - written
+			if n > space {
+				n = space
+			}
+			conn.tx.buf.Write(b[written : written+n])
+			written += n
+		}
+		conn.tx.mu.Unlock()
+		signal(conn.tx.readable)
+
+		if written == len(b) {
+			return written, nil
+		}
+
+		select {
+		case <-conn.tx.writable:
+		case <-conn.done:
+		case <-conn.writeDeadline.wait():
+		}
+	}
+}
+
+//Close closes both directions. The peer reads the remaining buffered data and io.EOF afterwards.
+func (conn *memConn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+		conn.rx.close()
+		conn.tx.close()
+	})
+	return nil
+}
+
+func (conn *memConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (conn *memConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func (conn *memConn) SetDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	conn.writeDeadline.set(t)
+	return nil
+}
+
+func (conn *memConn) SetReadDeadline(t time.Time) error {
+	conn.readDeadline.set(t)
+	return nil
+}
+
+func (conn *memConn) SetWriteDeadline(t time.Time) error {
+	conn.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,78 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemConn(t *testing.T) {
+	client, server := newMemPipe("client", "server", 8)
+
+	//Writes up to the buffer size do not block without a reader.
+	if n, err := client.Write([]byte("12345678")); n != 8 || err != nil {
+		t.Fatalf("Expected buffered write, got %d (%v)", n, err)
+	}
+
+	client.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := client.Write([]byte("9"))
+	var timeoutErr interface{ Timeout() bool }
+	if !errors.As(err, &timeoutErr) || !timeoutErr.Timeout() {
+		t.Fatalf("Expected timeout on a full buffer, got %v", err)
+	}
+
+	client.Close()
+	b := make([]byte, 16)
+	if n, err := server.Read(b); n != 8 || err != nil {
+		t.Fatalf("Expected buffered data after close, got %d (%v)", n, err)
+	}
+	if _, err := server.Read(b); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	if _, err := server.Write(b); err == nil {
+		t.Fatal("Expected write to a closed peer to fail")
+	}
+}
+
+func TestMemListener(t *testing.T) {
+	listener := NewMemListener("mem")
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetListeners(listener)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(msg)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr().String() != "mem" {
+		t.Fatalf("Expected address mem, got %s", server.Addr())
+	}
+
+	client := NewTCPClient(nil, 0, time.Second, 1024)
+	client.SetLogger(NopLogger)
+	client.SetDialer(listener.DialContext)
+	conn, err := client.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage([]byte("hello"))
+	if msg, err := conn.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("Expected hello, got %q (%v)", msg, err)
+	}
+
+	server.Stop()
+	wg.Wait()
+	if _, err := listener.Dial(); err == nil {
+		t.Fatal("Expected dial on a stopped server to fail")
+	}
+}
@@ -0,0 +1,228 @@
+package sc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultDurationBuckets are the upper bounds in seconds of the handler duration histogram.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+//DefaultSizeBuckets are the upper bounds in bytes of the histograms of the bytes transferred per connection.
+var DefaultSizeBuckets = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+
+//histogram counts observations in buckets with fixed upper bounds.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := HistogramSnapshot{Bounds: h.bounds, Counts: make([]uint64, len(h.counts)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		snapshot.Counts[i] = cumulative
+	}
+	return snapshot
+}
+
+//HistogramSnapshot is a snapshot of a histogram.
+//Counts[i] is the cumulative amount of observations less than or equal to Bounds[i].
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+//serverMetrics are the counters of a Server.
+type serverMetrics struct {
+	accepted      int64
+	failed        int64
+	panics        int64
+	handlerErrors int64
+
+	//bytesRead and bytesWritten contain the bytes of closed connections only.
+	//They are updated under server.connsMu when a connection is untracked.
+	bytesRead    int64
+	bytesWritten int64
+
+	handlerDuration *histogram
+	//connBytesRead and connBytesWritten observe the bytes of every connection when it is untracked.
+	connBytesRead    *histogram
+	connBytesWritten *histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{handlerDuration: newHistogram(DefaultDurationBuckets),
+		connBytesRead:    newHistogram(DefaultSizeBuckets),
+		connBytesWritten: newHistogram(DefaultSizeBuckets)}
+}
+
+//MetricsSnapshot is a snapshot of the metrics of a Server.
+type MetricsSnapshot struct {
+	//Accepted is the amount of connections and udp sessions that were admitted.
+	Accepted int64
+	//Rejected is the amount of connections rejected because the server was full.
+	Rejected int64
+	//Filtered is the amount of connections rejected by a ConnFilter.
+	Filtered int64
+	//Failed is the amount of failed accepts and TLS handshakes.
+	Failed int64
+	//Active is the amount of currently served connections.
+	Active int64
+
+	//BytesRead and BytesWritten are the bytes transferred over all connections, including the active ones.
+	BytesRead    int64
+	BytesWritten int64
+
+	//HandlerErrors is the amount of errors returned by handlers, HandlerPanics the amount of recovered panics.
+	HandlerErrors   int64
+	HandlerPanics   int64
+	HandlerDuration HistogramSnapshot
+
+	//ConnBytesRead and ConnBytesWritten are the distributions of the bytes transferred per closed connection.
+	ConnBytesRead    HistogramSnapshot
+	ConnBytesWritten HistogramSnapshot
+}
+
+//Metrics returns a snapshot of the metrics of the server.
+func (server *Server) Metrics() MetricsSnapshot {
+	snapshot := MetricsSnapshot{Accepted: atomic.LoadInt64(&server.metrics.accepted),
+		Rejected:         server.Rejected(),
+		Filtered:         server.Filtered(),
+		Failed:           atomic.LoadInt64(&server.metrics.failed),
+		Active:           server.CurClients(),
+		HandlerErrors:    atomic.LoadInt64(&server.metrics.handlerErrors),
+		HandlerPanics:    atomic.LoadInt64(&server.metrics.panics),
+		HandlerDuration:  server.metrics.handlerDuration.snapshot(),
+		ConnBytesRead:    server.metrics.connBytesRead.snapshot(),
+		ConnBytesWritten: server.metrics.connBytesWritten.snapshot()}
+
+	server.connsMu.Lock()
+	snapshot.BytesRead = server.metrics.bytesRead
+	snapshot.BytesWritten = server.metrics.bytesWritten
+	for _, conn := range server.conns {
+		snapshot.BytesRead += conn.BytesRead()
+		snapshot.BytesWritten += conn.BytesWritten()
+	}
+	server.connsMu.Unlock()
+
+	return snapshot
+}
+
+//WritePrometheus writes the snapshot to w in the Prometheus text exposition format.
+func (snapshot MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"sc_connections_accepted_total", "counter", "Connections admitted by the server.", snapshot.Accepted},
+		{"sc_connections_rejected_total", "counter", "Connections rejected because the server was full.", snapshot.Rejected},
+		{"sc_connections_filtered_total", "counter", "Connections rejected by a connection filter.", snapshot.Filtered},
+		{"sc_connections_failed_total", "counter", "Failed accepts and TLS handshakes.", snapshot.Failed},
+		{"sc_connections_active", "gauge", "Connections currently served.", snapshot.Active},
+		{"sc_read_bytes_total", "counter", "Bytes read from all connections.", snapshot.BytesRead},
+		{"sc_written_bytes_total", "counter", "Bytes written to all connections.", snapshot.BytesWritten},
+		{"sc_handler_errors_total", "counter", "Errors returned by connection handlers.", snapshot.HandlerErrors},
+		{"sc_handler_panics_total", "counter", "Recovered panics of connection handlers.", snapshot.HandlerPanics},
+	}
+
+	for _, metric := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n",
+			metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	histograms := []struct {
+		name, help string
+		value      HistogramSnapshot
+	}{
+		{"sc_handler_duration_seconds", "Duration of connection handlers.", snapshot.HandlerDuration},
+		{"sc_connection_read_bytes", "Bytes read per closed connection.", snapshot.ConnBytesRead},
+		{"sc_connection_written_bytes", "Bytes written per closed connection.", snapshot.ConnBytesWritten},
+	}
+
+	for _, histogram := range histograms {
+		err := writePrometheusHistogram(w, histogram.name, histogram.help, histogram.value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePrometheusHistogram(w io.Writer, name, help string, h HistogramSnapshot) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	if err != nil {
+		return err
+	}
+
+	for i, bound := range h.Bounds {
+		_, err = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.Counts[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n",
+		name, h.Count, name, strconv.FormatFloat(h.Sum, 'g', -1, 64), name, h.Count)
+	return err
+}
+
+//ServeMetrics serves the metrics of the server in the Prometheus text exposition format on addr under /metrics.
+//addr is usually a local address like "127.0.0.1:9100". The endpoint is closed when the server stops.
+//Returns the address the endpoint is bound to.
+func (server *Server) ServeMetrics(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		server.Metrics().WritePrometheus(w)
+	})
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go httpServer.Serve(listener)
+	go func() {
+		<-server.sigchan
+		httpServer.Close()
+	}()
+	return listener.Addr(), nil
+}
@@ -0,0 +1,75 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServerMetrics(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		b := make([]byte, 4)
+		n, _ := conn.Read(b)
+		conn.Write(b[:n])
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	addr, err := server.ServeMetrics("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	netConn, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	netConn.Write([]byte("ping"))
+	ioutil.ReadAll(netConn)
+	netConn.Close()
+	waitForClients(t, server, 0)
+
+	snapshot := server.Metrics()
+	if snapshot.Accepted != 1 || snapshot.BytesRead != 4 || snapshot.BytesWritten != 4 || snapshot.HandlerDuration.Count != 1 ||
+		snapshot.ConnBytesRead.Count != 1 || snapshot.ConnBytesWritten.Sum != 4 {
+		t.Fatalf("Unexpected snapshot %+v", snapshot)
+	}
+
+	var buf bytes.Buffer
+	if err := snapshot.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE sc_connections_accepted_total counter",
+		"sc_connections_accepted_total 1",
+		"sc_read_bytes_total 4",
+		`sc_handler_duration_seconds_bucket{le="+Inf"} 1`,
+		"sc_handler_duration_seconds_count 1",
+		`sc_connection_read_bytes_bucket{le="64"} 1`,
+		"sc_connection_written_bytes_sum 4",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatalf("Expected line %q in\n%s", line, buf.String())
+		}
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "sc_connections_accepted_total 1\n") {
+		t.Fatalf("Unexpected metrics endpoint response\n%s", body)
+	}
+}
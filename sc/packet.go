@@ -0,0 +1,204 @@
+package sc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//defaultPacketIdleTimeout is used for packet sessions if neither the idle timeout nor the default timeout is set.
+	defaultPacketIdleTimeout = 2 * time.Minute
+
+	//maxDatagramSize is the largest payload a udp datagram can carry.
+	maxDatagramSize = 65535
+
+	//minReadRetryDelay and maxReadRetryDelay bound the delay before reading again after a failed read of a packet socket.
+	minReadRetryDelay = 5 * time.Millisecond
+	maxReadRetryDelay = time.Second
+
+	//minPacketReapInterval bounds how often idle sessions are looked for with tiny idle timeouts.
+	minPacketReapInterval = time.Millisecond
+
+	//packetQueueSize is the amount of datagrams buffered per session before new datagrams are dropped.
+	packetQueueSize = 64
+)
+
+//packetConn is a virtual net.Conn for a single peer of a packet based server.
+//Every Read returns exactly one datagram. If b is too small, the rest of the datagram is discarded.
+type packetConn struct {
+	pc     net.PacketConn
+	remote net.Addr
+
+	in        chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	//lastActive is the unix nano timestamp of the last datagram that was sent or received.
+	lastActive int64
+}
+
+func newPacketConn(pc net.PacketConn, remote net.Addr, onClose func()) *packetConn {
+	return &packetConn{pc: pc,
+		remote:        remote,
+		in:            make(chan []byte, packetQueueSize),
+		done:          make(chan struct{}),
+		onClose:       onClose,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		lastActive:    time.Now().UnixNano()}
+}
+
+//deliver queues a datagram for Read. Datagrams are dropped if the queue is full or the conn is closed.
+func (p *packetConn) deliver(datagram []byte) {
+	atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+	select {
+	case <-p.done:
+	case p.in <- datagram:
+	default:
+	}
+}
+
+//idleSince returns how long the session has not seen any traffic.
+func (p *packetConn) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&p.lastActive)))
+}
+
+func (p *packetConn) Read(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, io.EOF
+	case <-p.readDeadline.wait():
+		return 0, timeoutError{}
+	default:
+	}
+
+	select {
+	case datagram := <-p.in:
+		return copy(b, datagram), nil
+	case <-p.done:
+		return 0, io.EOF
+	case <-p.readDeadline.wait():
+		return 0, timeoutError{}
+	}
+}
+
+func (p *packetConn) Write(b []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, errClosedConn
+	case <-p.writeDeadline.wait():
+		return 0, timeoutError{}
+	default:
+	}
+
+	atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+	return p.pc.WriteTo(b, p.remote)
+}
+
+//Close closes the session. The shared packet socket stays open.
+func (p *packetConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		if p.onClose != nil {
+			p.onClose()
+		}
+	})
+	return nil
+}
+
+func (p *packetConn) LocalAddr() net.Addr {
+	return p.pc.LocalAddr()
+}
+
+func (p *packetConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+func (p *packetConn) SetDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	p.writeDeadline.set(t)
+	return nil
+}
+
+func (p *packetConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+func (p *packetConn) SetWriteDeadline(t time.Time) error {
+	p.writeDeadline.set(t)
+	return nil
+}
+
+//packetSessions demultiplexes the datagrams of a net.PacketConn by remote address.
+type packetSessions struct {
+	pc net.PacketConn
+
+	mu       sync.Mutex
+	sessions map[string]*packetConn
+}
+
+func newPacketSessions(pc net.PacketConn) *packetSessions {
+	return &packetSessions{pc: pc, sessions: make(map[string]*packetConn)}
+}
+
+//get returns the session of addr. The bool is false if there is no session for addr.
+func (s *packetSessions) get(addr net.Addr) (*packetConn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.sessions[addr.String()]
+	return p, ok
+}
+
+//create opens a new session for addr. The session removes itself on Close.
+func (s *packetSessions) create(addr net.Addr) *packetConn {
+	key := addr.String()
+	var p *packetConn
+	p = newPacketConn(s.pc, addr, func() {
+		s.mu.Lock()
+		if s.sessions[key] == p {
+			delete(s.sessions, key)
+		}
+		s.mu.Unlock()
+	})
+
+	s.mu.Lock()
+	s.sessions[key] = p
+	s.mu.Unlock()
+	return p
+}
+
+//expire closes all sessions that have been idle for longer than idleTimeout.
+func (s *packetSessions) expire(idleTimeout time.Duration) {
+	now := time.Now()
+	for _, p := range s.list() {
+		if p.idleSince(now) > idleTimeout {
+			p.Close()
+		}
+	}
+}
+
+//closeAll closes every session.
+func (s *packetSessions) closeAll() {
+	for _, p := range s.list() {
+		p.Close()
+	}
+}
+
+func (s *packetSessions) list() []*packetConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*packetConn, 0, len(s.sessions))
+	for _, p := range s.sessions {
+		list = append(list, p)
+	}
+	return list
+}
@@ -0,0 +1,8 @@
+package sc
+
+//PeerCredentials are the credentials of the process on the other end of a unix domain socket.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
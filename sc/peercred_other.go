@@ -0,0 +1,12 @@
+// +build !linux
+
+package sc
+
+import (
+	"net"
+)
+
+//peerCredentials is not supported on this platform.
+func peerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	return nil, ErrNoPeerCredentials
+}
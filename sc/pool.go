@@ -0,0 +1,331 @@
+package sc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//poolMaintenanceInterval is the interval in which idle connections are expired and refilled.
+const poolMaintenanceInterval = time.Second
+
+//PoolConfig configures a Pool.
+type PoolConfig struct {
+	//MinIdle is the amount of idle connections the pool keeps open in the background. It is capped at MaxIdle.
+	MinIdle int
+
+	//MaxIdle is the maximum amount of idle connections. Surplus connections are closed on Put.
+	//A value <= 0 means no limit.
+	MaxIdle int
+
+	//MaxOpen is the maximum amount of open connections, idle and in use.
+	//Get waits for a free connection once it is reached. A value <= 0 means no limit.
+	MaxOpen int
+
+	//MaxLifetime is the maximum time a connection is reused after it was established.
+	//A value <= 0 means connections are reused forever.
+	MaxLifetime time.Duration
+
+	//HealthCheck is called for every idle connection on Get. Connections failing the check are closed.
+	//HealthCheck may be nil.
+	HealthCheck func(*Conn) error
+}
+
+//PoolStats is a snapshot of the counters of a Pool.
+type PoolStats struct {
+	//Hits is the amount of Get calls that were served by an idle connection.
+	Hits int64
+	//Misses is the amount of Get calls that had to dial a new connection.
+	Misses int64
+	//Waits is the amount of Get calls that had to wait because MaxOpen was reached.
+	Waits int64
+	//WaitDuration is the total time spent waiting for a connection.
+	WaitDuration time.Duration
+
+	Open int
+	Idle int
+}
+
+//Pool is a pool of connections of a Client. Take connections with Get and hand them back with Put,
+//or Discard them if they are broken. Pool is safe for concurrent use.
+type Pool struct {
+	client *Client
+	config PoolConfig
+
+	mu      sync.Mutex
+	idle    []*Conn
+	created map[*Conn]time.Time
+	open    int
+	waiters []chan struct{}
+	closed  bool
+	stats   PoolStats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+//NewPool is the constructor for a Pool of connections dialed by client.
+//The pool starts filling up to config.MinIdle idle connections in the background.
+func NewPool(client *Client, config PoolConfig) *Pool {
+	if config.MaxIdle > 0 && config.MinIdle > config.MaxIdle {
+		//Refilled connections beyond MaxIdle would be closed by Put and dialed again right away.
+		config.MinIdle = config.MaxIdle
+	}
+	pool := &Pool{client: client,
+		config:  config,
+		created: make(map[*Conn]time.Time),
+		done:    make(chan struct{})}
+
+	pool.wg.Add(1)
+	go pool.maintain()
+	return pool
+}
+
+//Get returns an idle connection or dials a new one.
+//If MaxOpen connections are open, Get waits until a connection is put back, discarded or ctx is done.
+//Every connection returned by Get has to be handed back with Put or Discard.
+func (pool *Pool) Get(ctx context.Context) (*Conn, error) {
+	pool.mu.Lock()
+	for {
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(pool.idle); n > 0 {
+			conn := pool.idle[n-1]
+			pool.idle = pool.idle[:n-1]
+
+			if pool.expired(conn) {
+				pool.closeLocked(conn)
+				continue
+			}
+			pool.mu.Unlock()
+
+			if pool.config.HealthCheck != nil && pool.config.HealthCheck(conn) != nil {
+				pool.Discard(conn)
+				pool.mu.Lock()
+				continue
+			}
+
+			pool.mu.Lock()
+			pool.stats.Hits++
+			pool.mu.Unlock()
+			return conn, nil
+		}
+
+		if pool.config.MaxOpen <= 0 || pool.open < pool.config.MaxOpen {
+			pool.open++
+			pool.stats.Misses++
+			pool.mu.Unlock()
+			return pool.dial(ctx)
+		}
+
+		wakeup := make(chan struct{}, 1)
+		pool.waiters = append(pool.waiters, wakeup)
+		pool.stats.Waits++
+		pool.mu.Unlock()
+
+		start := time.Now()
+		woken := false
+		select {
+		case <-wakeup:
+			woken = true
+		case <-ctx.Done():
+		}
+
+		pool.mu.Lock()
+		pool.stats.WaitDuration += time.Since(start)
+		if !woken {
+			//The waiter may have been woken after ctx was done.
+			select {
+			case <-wakeup:
+				woken = true
+			default:
+				pool.removeWaiter(wakeup)
+			}
+		}
+		if ctx.Err() != nil {
+			//Pass the wakeup on, so the freed slot is not lost.
+			if woken {
+				pool.wakeWaiter()
+			}
+			pool.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//Put hands conn back to the pool. conn is closed instead if the pool is closed or full,
+//or if conn exceeded its MaxLifetime.
+func (pool *Pool) Put(conn *Conn) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if _, ok := pool.created[conn]; !ok {
+		return
+	}
+
+	if pool.closed || pool.expired(conn) || (pool.config.MaxIdle > 0 && len(pool.idle) >= pool.config.MaxIdle) {
+		pool.closeLocked(conn)
+		return
+	}
+
+	pool.idle = append(pool.idle, conn)
+	pool.wakeWaiter()
+}
+
+//Discard closes conn and frees its slot in the pool. Use Discard for broken connections.
+func (pool *Pool) Discard(conn *Conn) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if _, ok := pool.created[conn]; !ok {
+		return
+	}
+	pool.closeLocked(conn)
+}
+
+//Stats returns a snapshot of the counters of the pool.
+func (pool *Pool) Stats() PoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats := pool.stats
+	stats.Open = pool.open
+	stats.Idle = len(pool.idle)
+	return stats
+}
+
+//Close closes all idle connections and stops the pool. Connections that are in use are closed when they are put back.
+//Waiting Get calls return ErrPoolClosed.
+func (pool *Pool) Close() error {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil
+	}
+	pool.closed = true
+	for _, conn := range pool.idle {
+		pool.closeLocked(conn)
+	}
+	pool.idle = nil
+	for len(pool.waiters) > 0 {
+		pool.wakeWaiter()
+	}
+	pool.mu.Unlock()
+
+	close(pool.done)
+	pool.wg.Wait()
+	return nil
+}
+
+//dial dials a new connection for a slot that has already been reserved in pool.open.
+func (pool *Pool) dial(ctx context.Context) (*Conn, error) {
+	conn, err := pool.client.Dial(ctx)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if err != nil {
+		pool.open--
+		pool.wakeWaiter()
+		return nil, err
+	}
+	pool.created[conn] = time.Now()
+	return conn, nil
+}
+
+//maintain expires idle connections and keeps MinIdle connections open until the pool is closed.
+func (pool *Pool) maintain() {
+	defer pool.wg.Done()
+	ticker := time.NewTicker(poolMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		pool.expireIdle()
+		pool.fillIdle()
+
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pool *Pool) expireIdle() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	idle := pool.idle[:0]
+	for _, conn := range pool.idle {
+		if pool.expired(conn) {
+			pool.closeLocked(conn)
+			continue
+		}
+		idle = append(idle, conn)
+	}
+	pool.idle = idle
+}
+
+func (pool *Pool) fillIdle() {
+	for {
+		pool.mu.Lock()
+		if pool.closed || len(pool.idle) >= pool.config.MinIdle ||
+			(pool.config.MaxOpen > 0 && pool.open >= pool.config.MaxOpen) {
+			pool.mu.Unlock()
+			return
+		}
+		pool.open++
+		pool.mu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-pool.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		conn, err := pool.dial(ctx)
+		cancel()
+		if err != nil {
+			return
+		}
+		pool.Put(conn)
+	}
+}
+
+//expired reports whether conn exceeded MaxLifetime. pool.mu has to be held.
+func (pool *Pool) expired(conn *Conn) bool {
+	return pool.config.MaxLifetime > 0 && time.Since(pool.created[conn]) > pool.config.MaxLifetime
+}
+
+//closeLocked closes conn and frees its slot. pool.mu has to be held.
+func (pool *Pool) closeLocked(conn *Conn) {
+	conn.Close()
+	delete(pool.created, conn)
+	pool.open--
+	pool.wakeWaiter()
+}
+
+//wakeWaiter wakes the longest waiting Get call. pool.mu has to be held.
+func (pool *Pool) wakeWaiter() {
+	if len(pool.waiters) == 0 {
+		return
+	}
+	wakeup := pool.waiters[0]
+	pool.waiters = pool.waiters[1:]
+	wakeup <- struct{}{}
+}
+
+//removeWaiter removes wakeup from the waiters if it was not woken. pool.mu has to be held.
+func (pool *Pool) removeWaiter(wakeup chan struct{}) {
+	for i, waiter := range pool.waiters {
+		if waiter == wakeup {
+			pool.waiters = append(pool.waiters[:i], pool.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//startEchoServer starts an echo server on an ephemeral port. Returns the port and a function stopping the server.
+func startEchoServer(t *testing.T) (int, func()) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		b := make([]byte, 1024)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			conn.Write(b[:n])
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addrPort(server.Addr()), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		wg.Wait()
+	}
+}
+
+func TestPoolReuse(t *testing.T) {
+	port, stop := startEchoServer(t)
+	defer stop()
+
+	pool := NewPool(NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024), PoolConfig{MaxIdle: 2})
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(conn)
+
+	reused, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused != conn {
+		t.Fatal("Expected idle connection to be reused")
+	}
+	pool.Put(reused)
+
+	stats := pool.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Open != 1 || stats.Idle != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestPoolWaitsWhenExhausted(t *testing.T) {
+	port, stop := startEchoServer(t)
+	defer stop()
+
+	pool := NewPool(NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024), PoolConfig{MaxOpen: 1})
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pool.Put(conn)
+	}()
+	waited, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(waited)
+
+	if stats := pool.Stats(); stats.Waits != 2 || stats.WaitDuration <= 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	port, stop := startEchoServer(t)
+	defer stop()
+
+	unhealthy := errors.New("unhealthy")
+	pool := NewPool(NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024), PoolConfig{
+		HealthCheck: func(conn *Conn) error { return unhealthy },
+	})
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(conn)
+
+	fresh, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == conn {
+		t.Fatal("Expected unhealthy connection to be replaced")
+	}
+	pool.Put(fresh)
+
+	pool.Close()
+	if _, err := pool.Get(context.Background()); err != ErrPoolClosed {
+		t.Fatalf("Expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolMinIdleCappedAtMaxIdle(t *testing.T) {
+	port, stop := startEchoServer(t)
+	defer stop()
+
+	var dials int64
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024)
+	client.SetDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		atomic.AddInt64(&dials, 1)
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, address)
+	})
+	pool := NewPool(client, PoolConfig{MinIdle: 2, MaxIdle: 1})
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Idle != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 idle connection, got %+v", pool.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	pool.Close()
+
+	if n := atomic.LoadInt64(&dials); n != 1 {
+		t.Fatalf("Expected a single dial, got %d", n)
+	}
+}
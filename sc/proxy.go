@@ -0,0 +1,331 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//defaultProxyHeaderTimeout bounds reading a PROXY header if ProxyProtocolConfig.Timeout is not set.
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+//maxProxyV1HeaderSize is the maximum size of a PROXY protocol v1 header including the trailing CRLF.
+const maxProxyV1HeaderSize = 107
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte{'\r', '\n', '\r', '\n', 0, '\r', '\n', 'Q', 'U', 'I', 'T', '\n'}
+)
+
+//Types of the TLVs defined by the PROXY protocol v2 specification.
+const (
+	ProxyTLVALPN      byte = 0x01
+	ProxyTLVAuthority byte = 0x02
+	ProxyTLVCRC32C    byte = 0x03
+	ProxyTLVNoop      byte = 0x04
+	ProxyTLVUniqueID  byte = 0x05
+	ProxyTLVSSL       byte = 0x20
+	ProxyTLVNetNS     byte = 0x30
+)
+
+//ProxyTLV is a type-length-value extension of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+//ProxyProtocolConfig configures the PROXY protocol on a Server.
+type ProxyProtocolConfig struct {
+	//Trusted are the ips and CIDRs of the load balancers, for example "10.0.0.0/8".
+	//Connections from trusted peers have to start with a PROXY header, all other connections are served as they are.
+	Trusted []string
+	//Timeout bounds reading the header. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+//proxyHeader is the parsed PROXY header of a connection.
+type proxyHeader struct {
+	//source and destination are nil for LOCAL and UNKNOWN headers, the addresses of the connection are kept then.
+	source      net.Addr
+	destination net.Addr
+	tlvs        []ProxyTLV
+}
+
+//proxyConn overrides the addresses of a connection with the ones of its PROXY header.
+type proxyConn struct {
+	net.Conn
+	header   *proxyHeader
+	upstream net.Addr
+}
+
+//RemoteAddr returns the address of the client as sent by the load balancer.
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	if conn.header.source != nil {
+		return conn.header.source
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+//LocalAddr returns the address the client connected to as sent by the load balancer.
+func (conn *proxyConn) LocalAddr() net.Addr {
+	if conn.header.destination != nil {
+		return conn.header.destination
+	}
+	return conn.Conn.LocalAddr()
+}
+
+//unwrap returns the wrapped connection.
+func (conn *proxyConn) unwrap() net.Conn {
+	return conn.Conn
+}
+
+//SetProxyProtocol enables parsing of PROXY protocol v1 and v2 headers on connections from trusted load balancers,
+//so RemoteAddr of the connection returns the address of the client. The header is read before filters
+//and the TLS handshake run, so filters see the address of the client as well. Has to be called before Start.
+//Packet based servers ignore the PROXY protocol. Returns an error if a trusted rule is invalid.
+func (server *Server) SetProxyProtocol(config ProxyProtocolConfig) error {
+	trusted, err := parseIPRules(config.Trusted)
+	if err != nil {
+		return err
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultProxyHeaderTimeout
+	}
+
+	server.proxyTrusted = trusted
+	server.proxyTimeout = config.Timeout
+	return nil
+}
+
+//readProxyHeader reads the PROXY header of conn if it comes from a trusted load balancer and wraps conn accordingly.
+func (server *Server) readProxyHeader(conn *Conn) error {
+	if len(server.proxyTrusted) == 0 || server.proxyTimeout <= 0 {
+		return nil
+	}
+	ip := addrIP(conn.RemoteAddr())
+	if ip == nil || !containsIP(server.proxyTrusted, ip) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(conn.ctx, server.proxyTimeout)
+	defer cancel()
+	release, err := conn.bindToContext(ctx, conn.Conn)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	header, err := readProxyHeader(conn.Conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	conn.proxy = &proxyConn{Conn: conn.Conn, header: header, upstream: conn.Conn.RemoteAddr()}
+	conn.Conn = conn.proxy
+	return nil
+}
+
+//ProxyTLVs returns the TLVs of the PROXY protocol v2 header of the connection.
+//It is nil if the connection was not proxied or the header did not contain TLVs.
+func (c *Conn) ProxyTLVs() []ProxyTLV {
+	if c.proxy == nil {
+		return nil
+	}
+	return c.proxy.header.tlvs
+}
+
+//ProxyTLV returns the value of the first TLV of type typ, see ProxyTLVs.
+func (c *Conn) ProxyTLV(typ byte) ([]byte, bool) {
+	for _, tlv := range c.ProxyTLVs() {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+//ProxiedBy returns the address of the load balancer that sent the PROXY header, or nil if the connection was not proxied.
+func (c *Conn) ProxiedBy() net.Addr {
+	if c.proxy == nil {
+		return nil
+	}
+	return c.proxy.upstream
+}
+
+//readProxyHeader reads a PROXY protocol v1 or v2 header from r without reading past its end.
+func readProxyHeader(r io.Reader) (*proxyHeader, error) {
+	//The shortest v1 header "PROXY UNKNOWN\r\n" is longer than the v2 signature, so reading it is safe for both versions.
+	start := make([]byte, len(proxyV2Signature))
+	_, err := io.ReadFull(r, start)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(start, proxyV1Prefix):
+		return readProxyV1(r, start)
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+}
+
+//readProxyV1 reads the rest of a v1 header starting with start byte by byte up to the CRLF.
+func readProxyV1(r io.Reader, start []byte) (*proxyHeader, error) {
+	line := append(make([]byte, 0, maxProxyV1HeaderSize), start...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1HeaderSize {
+			return nil, ErrInvalidProxyHeader
+		}
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	return parseProxyV1(string(line[:len(line)-2]))
+}
+
+//parseProxyV1 parses a v1 header line without the trailing CRLF.
+func parseProxyV1(line string) (*proxyHeader, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &proxyHeader{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	v4 := fields[1] == "TCP4"
+	source, err := parseProxyV1Addr(fields[2], fields[4], v4)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseProxyV1Addr(fields[3], fields[5], v4)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyHeader{source: source, destination: destination}, nil
+}
+
+func parseProxyV1Addr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, ErrInvalidProxyHeader
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+//readProxyV2 reads a v2 header after its signature.
+func readProxyV2(r io.Reader) (*proxyHeader, error) {
+	fixed := make([]byte, 4)
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	verCmd, family := fixed[0], fixed[1]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[2:]))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	switch verCmd & 0x0f {
+	case 0:
+		//LOCAL, for example health checks of the load balancer. The addresses have to be ignored.
+		return &proxyHeader{}, nil
+	case 1:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	header := &proxyHeader{}
+	datagram := family&0x0f == 2
+	var addrLen int
+	switch family >> 4 {
+	case 0:
+		//UNSPEC, the addresses of the connection are kept.
+	case 1:
+		addrLen = 2*net.IPv4len + 4
+		if len(body) < addrLen {
+			return nil, ErrInvalidProxyHeader
+		}
+		header.source, header.destination = proxyV2IPAddrs(body, net.IPv4len, datagram)
+	case 2:
+		addrLen = 2*net.IPv6len + 4
+		if len(body) < addrLen {
+			return nil, ErrInvalidProxyHeader
+		}
+		header.source, header.destination = proxyV2IPAddrs(body, net.IPv6len, datagram)
+	case 3:
+		addrLen = 2 * 108
+		if len(body) < addrLen {
+			return nil, ErrInvalidProxyHeader
+		}
+		header.source = &net.UnixAddr{Name: unixPath(body[:108]), Net: "unix"}
+		header.destination = &net.UnixAddr{Name: unixPath(body[108:216]), Net: "unix"}
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	header.tlvs, err = parseProxyTLVs(body[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+//proxyV2IPAddrs parses the source and destination address of an INET or INET6 v2 header.
+func proxyV2IPAddrs(body []byte, ipLen int, datagram bool) (net.Addr, net.Addr) {
+	sourceIP := net.IP(append([]byte{}, body[:ipLen]...))
+	destinationIP := net.IP(append([]byte{}, body[ipLen:2*ipLen]...))
+	sourcePort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	destinationPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+
+	if datagram {
+		return &net.UDPAddr{IP: sourceIP, Port: sourcePort}, &net.UDPAddr{IP: destinationIP, Port: destinationPort}
+	}
+	return &net.TCPAddr{IP: sourceIP, Port: sourcePort}, &net.TCPAddr{IP: destinationIP, Port: destinationPort}
+}
+
+//unixPath returns the null terminated path in b.
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseProxyTLVs(b []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrInvalidProxyHeader
+		}
+		length := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+length {
+			return nil, ErrInvalidProxyHeader
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: b[0], Value: append([]byte{}, b[3:3+length]...)})
+		b = b[3+length:]
+	}
+	return tlvs, nil
+}
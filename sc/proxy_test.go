@@ -0,0 +1,136 @@
+package sc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func proxyV2Header(cmd, family byte, addrs []byte, tlvs ...ProxyTLV) []byte {
+	body := append([]byte{}, addrs...)
+	for _, tlv := range tlvs {
+		body = append(body, tlv.Type, 0, 0)
+		binary.BigEndian.PutUint16(body[len(body)-2:], uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(body)))
+	return append(header, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	inet := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x01, 0xbb}
+
+	testCases := []struct {
+		desc        string
+		header      []byte
+		source      string
+		destination string
+		tlvs        int
+		err         error
+	}{
+		{desc: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"), source: "192.0.2.1:12345", destination: "198.51.100.1:443"},
+		{desc: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"), source: "[2001:db8::1]:12345", destination: "[2001:db8::2]:443"},
+		{desc: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{desc: "v1 family mismatch", header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 12345 443\r\n"), err: ErrInvalidProxyHeader},
+		{desc: "v1 bad port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 123456 443\r\n"), err: ErrInvalidProxyHeader},
+		{desc: "v2 tcp4 with tlvs", header: proxyV2Header(1, 0x11, inet, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")}), source: "192.0.2.1:12345", destination: "198.51.100.1:443", tlvs: 1},
+		{desc: "v2 local", header: proxyV2Header(0, 0x00, nil)},
+		{desc: "v2 short addresses", header: proxyV2Header(1, 0x11, inet[:6]), err: ErrInvalidProxyHeader},
+		{desc: "v2 truncated tlv", header: append(proxyV2Header(1, 0x11, inet), 0), err: ErrInvalidProxyHeader},
+		{desc: "no header", header: []byte("GET / HTTP/1.1\r\n"), err: ErrInvalidProxyHeader},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			header := tC.header
+			if tC.desc == "v2 truncated tlv" {
+				//Count the dangling byte in the length of the header.
+				binary.BigEndian.PutUint16(header[14:16], binary.BigEndian.Uint16(header[14:16])+1)
+			}
+			r := bytes.NewReader(append(append([]byte{}, header...), "payload"...))
+
+			parsed, err := readProxyHeader(r)
+			if err != tC.err {
+				t.Fatalf("Expected error %v, got %v", tC.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if tC.source == "" {
+				if parsed.source != nil || parsed.destination != nil {
+					t.Fatalf("Expected no addresses, got %v and %v", parsed.source, parsed.destination)
+				}
+			} else if parsed.source.String() != tC.source || parsed.destination.String() != tC.destination {
+				t.Fatalf("Expected %s -> %s, got %s -> %s", tC.source, tC.destination, parsed.source, parsed.destination)
+			}
+			if len(parsed.tlvs) != tC.tlvs {
+				t.Fatalf("Expected %d tlvs, got %d", tC.tlvs, len(parsed.tlvs))
+			}
+
+			//The header must be consumed exactly.
+			if rest, _ := ioutil.ReadAll(r); string(rest) != "payload" {
+				t.Fatalf("Expected payload after the header, got %q", rest)
+			}
+		})
+	}
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	type result struct {
+		remote    string
+		authority string
+	}
+	results := make(chan result, 1)
+
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	if err := server.SetProxyProtocol(ProxyProtocolConfig{Trusted: []string{"127.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		authority, _ := conn.ProxyTLV(ProxyTLVAuthority)
+		results <- result{remote: conn.RemoteAddr().String(), authority: string(authority)}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	inet := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x01, 0xbb}
+	netConn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	netConn.Write(proxyV2Header(1, 0x11, inet, ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.com")}))
+
+	got := <-results
+	if got.remote != "192.0.2.1:12345" || got.authority != "example.com" {
+		t.Fatalf("Expected client address and authority from the header, got %+v", got)
+	}
+
+	//A trusted peer without a header is rejected before the handler runs.
+	netConn, err = net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	netConn.Write([]byte("hello world, no header here"))
+	if _, err := netConn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected connection without header to be closed")
+	}
+	if failed := server.Metrics().Failed; failed != 1 {
+		t.Fatalf("Expected 1 failed connection, got %d", failed)
+	}
+}
@@ -0,0 +1,232 @@
+package sc
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/beeemT/Packages/netutil"
+)
+
+//rateLimiterPruneSize is the amount of tracked peers above which idle peers are pruned.
+const rateLimiterPruneSize = 1024
+
+//RateLimiterConfig configures a RateLimiter. Limits with a value <= 0 are disabled.
+type RateLimiterConfig struct {
+	//Rate is the amount of new connections per second a peer may open on average.
+	Rate float64
+	//Burst is the amount of new connections a peer may open at once. Defaults to 1 if Rate is set.
+	Burst int
+
+	//MaxConcurrent is the maximum amount of concurrent connections of a peer.
+	MaxConcurrent int
+
+	//IPv4Prefix and IPv6Prefix group addresses into a single peer, for example 24 and 64.
+	//They default to 32 and 128, so every address is a peer of its own.
+	IPv4Prefix int
+	IPv6Prefix int
+
+	//BanThreshold is the amount of consecutively rejected connections after which a peer is banned for BanDuration.
+	BanThreshold int
+	BanDuration  time.Duration
+}
+
+//RateLimiterStats is a snapshot of the counters of a RateLimiter.
+type RateLimiterStats struct {
+	Allowed     int64
+	RateLimited int64
+	CapExceeded int64
+	Banned      int64
+}
+
+//peerState is the state of a single peer of a RateLimiter.
+type peerState struct {
+	tokens     float64
+	lastRefill time.Time
+	conns      int
+	rejections int
+}
+
+//RateLimiter is a ConnFilter limiting the rate of new connections and the concurrent connections per peer
+//with a token bucket per peer. Peers can be banned temporarily, either manually or automatically.
+//Connections without an ip, like unix domain socket connections, are always allowed.
+type RateLimiter struct {
+	config RateLimiterConfig
+
+	mu    sync.Mutex
+	peers map[string]*peerState
+	bans  map[string]time.Time
+	stats RateLimiterStats
+}
+
+//NewRateLimiter is the constructor for a RateLimiter. Add it to a server with Server.AddFilter.
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	if config.Rate > 0 && config.Burst <= 0 {
+		config.Burst = 1
+	}
+	if config.IPv4Prefix <= 0 || config.IPv4Prefix > 32 {
+		config.IPv4Prefix = 32
+	}
+	if config.IPv6Prefix <= 0 || config.IPv6Prefix > 128 {
+		config.IPv6Prefix = 128
+	}
+
+	return &RateLimiter{config: config,
+		peers: make(map[string]*peerState),
+		bans:  make(map[string]time.Time)}
+}
+
+//Allow implements ConnFilter.
+func (limiter *RateLimiter) Allow(addr net.Addr) error {
+	ip := addrIP(addr)
+	if ip == nil {
+		return nil
+	}
+	key := limiter.key(ip)
+	now := time.Now()
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if until, ok := limiter.bans[key]; ok {
+		if now.Before(until) {
+			limiter.stats.Banned++
+			return ErrBanned
+		}
+		delete(limiter.bans, key)
+	}
+
+	if len(limiter.peers) > rateLimiterPruneSize {
+		limiter.prune(now)
+	}
+
+	peer, ok := limiter.peers[key]
+	if !ok {
+		peer = &peerState{tokens: float64(limiter.config.Burst), lastRefill: now}
+		limiter.peers[key] = peer
+	}
+
+	if limiter.config.MaxConcurrent > 0 && peer.conns >= limiter.config.MaxConcurrent {
+		limiter.stats.CapExceeded++
+		limiter.rejected(key, peer, now)
+		return ErrTooManyConns
+	}
+
+	if limiter.config.Rate > 0 {
+		limiter.refill(peer, now)
+		if peer.tokens < 1 {
+			limiter.stats.RateLimited++
+			limiter.rejected(key, peer, now)
+			return ErrRateLimited
+		}
+		peer.tokens--
+	}
+
+	peer.conns++
+	peer.rejections = 0
+	limiter.stats.Allowed++
+	return nil
+}
+
+//Release implements ConnFilter.
+func (limiter *RateLimiter) Release(addr net.Addr) {
+	ip := addrIP(addr)
+	if ip == nil {
+		return
+	}
+	key := limiter.key(ip)
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if peer, ok := limiter.peers[key]; ok && peer.conns > 0 {
+		peer.conns--
+	}
+}
+
+//Ban rejects all connections of the peer of ip for duration.
+func (limiter *RateLimiter) Ban(ip net.IP, duration time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.bans[limiter.key(ip)] = time.Now().Add(duration)
+}
+
+//Unban lifts the ban of the peer of ip.
+func (limiter *RateLimiter) Unban(ip net.IP) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	delete(limiter.bans, limiter.key(ip))
+}
+
+//Bans returns the currently banned peers with the end of their ban.
+//Peers are keys in CIDR notation of the configured prefix length.
+func (limiter *RateLimiter) Bans() map[string]time.Time {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	bans := make(map[string]time.Time, len(limiter.bans))
+	for key, until := range limiter.bans {
+		if now.Before(until) {
+			bans[key] = until
+		}
+	}
+	return bans
+}
+
+//Stats returns a snapshot of the counters of the rate limiter.
+func (limiter *RateLimiter) Stats() RateLimiterStats {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.stats
+}
+
+//key returns the peer key of ip, that is the network of ip with the configured prefix length.
+func (limiter *RateLimiter) key(ip net.IP) string {
+	if netutil.IsIPv4(ip) {
+		mask := net.CIDRMask(limiter.config.IPv4Prefix, 8*net.IPv4len)
+		return (&net.IPNet{IP: ip.To4().Mask(mask), Mask: mask}).String()
+	}
+	mask := net.CIDRMask(limiter.config.IPv6Prefix, 8*net.IPv6len)
+	return (&net.IPNet{IP: ip.To16().Mask(mask), Mask: mask}).String()
+}
+
+//refill adds the tokens earned since the last refill to the bucket of peer. limiter.mu has to be held.
+func (limiter *RateLimiter) refill(peer *peerState, now time.Time) {
+	peer.tokens += now.Sub(peer.lastRefill).Seconds() * limiter.config.Rate
+	if peer.tokens > float64(limiter.config.Burst) {
+		peer.tokens = float64(limiter.config.Burst)
+	}
+	peer.lastRefill = now
+}
+
+//rejected counts a rejection of peer and bans it once the ban threshold is reached. limiter.mu has to be held.
+func (limiter *RateLimiter) rejected(key string, peer *peerState, now time.Time) {
+	peer.rejections++
+	if limiter.config.BanThreshold > 0 && peer.rejections >= limiter.config.BanThreshold {
+		limiter.bans[key] = now.Add(limiter.config.BanDuration)
+		peer.rejections = 0
+	}
+}
+
+//prune forgets peers without connections whose bucket is full and removes expired bans. limiter.mu has to be held.
+func (limiter *RateLimiter) prune(now time.Time) {
+	for key, peer := range limiter.peers {
+		if peer.conns > 0 {
+			continue
+		}
+		if limiter.config.Rate > 0 {
+			limiter.refill(peer, now)
+			if peer.tokens < float64(limiter.config.Burst) {
+				continue
+			}
+		}
+		delete(limiter.peers, key)
+	}
+
+	for key, until := range limiter.bans {
+		if !now.Before(until) {
+			delete(limiter.bans, key)
+		}
+	}
+}
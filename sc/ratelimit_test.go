@@ -0,0 +1,109 @@
+package sc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{Rate: 0.001, Burst: 2, IPv4Prefix: 24})
+
+	testCases := []struct {
+		addr     string
+		expected error
+	}{
+		{addr: "10.0.0.1", expected: nil},
+		{addr: "10.0.0.2", expected: nil},
+		{addr: "10.0.0.3", expected: ErrRateLimited},
+		{addr: "10.0.1.1", expected: nil},
+		{addr: "fe80::1", expected: nil},
+	}
+
+	for _, tC := range testCases {
+		if err := limiter.Allow(tcpAddr(tC.addr)); err != tC.expected {
+			t.Fatalf("%s: Expected %v, got %v", tC.addr, tC.expected, err)
+		}
+	}
+
+	stats := limiter.Stats()
+	if stats.Allowed != 4 || stats.RateLimited != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiterConcurrentCapAndBan(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{MaxConcurrent: 1, BanThreshold: 2, BanDuration: time.Hour})
+	addr := tcpAddr("192.168.1.1")
+
+	if err := limiter.Allow(addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.Allow(addr); err != ErrTooManyConns {
+		t.Fatalf("Expected ErrTooManyConns, got %v", err)
+	}
+	limiter.Release(addr)
+	if err := limiter.Allow(addr); err != nil {
+		t.Fatalf("Expected released slot to be reusable, got %v", err)
+	}
+
+	//Second consecutive rejection reaches the ban threshold.
+	if err := limiter.Allow(addr); err != ErrTooManyConns {
+		t.Fatalf("Expected ErrTooManyConns, got %v", err)
+	}
+	if err := limiter.Allow(addr); err != ErrTooManyConns {
+		t.Fatalf("Expected ErrTooManyConns, got %v", err)
+	}
+	limiter.Release(addr)
+	if err := limiter.Allow(addr); err != ErrBanned {
+		t.Fatalf("Expected ErrBanned, got %v", err)
+	}
+	if _, ok := limiter.Bans()["192.168.1.1/32"]; !ok {
+		t.Fatalf("Expected ban entry, got %v", limiter.Bans())
+	}
+
+	limiter.Unban(net.ParseIP("192.168.1.1"))
+	if err := limiter.Allow(addr); err != nil {
+		t.Fatalf("Expected unbanned peer to be allowed, got %v", err)
+	}
+}
+
+func TestServerFilter(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{MaxConcurrent: 1})
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.AddFilter(limiter)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		<-ctx.Done()
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Shutdown(context.Background())
+
+	for i := 0; i < 2; i++ {
+		netConn, err := net.Dial("tcp", loopback(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer netConn.Close()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for server.Filtered() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 filtered connection, got %d", server.Filtered())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := limiter.Stats(); stats.CapExceeded != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,175 @@
+package sc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//ConnState is the state of a reconnecting client.
+type ConnState int
+
+const (
+	//StateConnecting means that the client is dialing the server.
+	StateConnecting ConnState = iota
+
+	//StateConnected means that a connection is established and the handle function is running.
+	StateConnected
+
+	//StateBackingOff means that the client waits before the next dial attempt.
+	StateBackingOff
+
+	//StateStopped means that the client stopped reconnecting because its context is done.
+	StateStopped
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackingOff:
+		return "backing-off"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+//Backoff configures the delays between the reconnection attempts of a client.
+//The n-th consecutive delay is Initial * Multiplier^n, capped at Max.
+//Jitter is the fraction of the delay that is randomized, 0.2 means that the delay varies by up to ±20%.
+//Unset Initial, Max and Multiplier fields are taken from DefaultBackoff.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+//DefaultBackoff is used by clients without a Backoff.
+var DefaultBackoff = Backoff{Initial: 100 * time.Millisecond, Max: 30 * time.Second, Multiplier: 2, Jitter: 0.2}
+
+//withDefaults returns b with its unset fields taken from DefaultBackoff. A zero Backoff becomes DefaultBackoff.
+func (b Backoff) withDefaults() Backoff {
+	if b == (Backoff{}) {
+		return DefaultBackoff
+	}
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoff.Initial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoff.Max
+	}
+	if b.Multiplier <= 0 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	return b
+}
+
+//delay returns the delay before the attempt-th consecutive reconnection attempt, starting with 0.
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 0; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Multiplier
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+//SetBackoff sets the backoff used by ConnectReconnecting. A zero Backoff means DefaultBackoff.
+func (client *Client) SetBackoff(backoff Backoff) {
+	client.backoff = backoff
+}
+
+//OnStateChange registers a callback that is called on every state transition of ConnectReconnecting.
+//err is the error that caused the transition, if any. The callback is called synchronously and must not block.
+func (client *Client) OnStateChange(callback func(state ConnState, err error)) {
+	client.onStateChange = callback
+}
+
+//ConnectReconnecting keeps the client connected to the server until ctx is done.
+//handle is called for every new connection. Once handle returns, the connection is closed and the client reconnects.
+//Failed dial attempts are retried with exponential backoff and jitter, see SetBackoff. The backoff is reset
+//once a connection stayed up for the maximum delay of the backoff.
+//When ctx is done the current connection is closed, so handle should return on read and write errors.
+//The returned waitgroup is done once the client stopped.
+func (client *Client) ConnectReconnecting(ctx context.Context, handle func(*Conn, ...interface{}), a ...interface{}) *sync.WaitGroup {
+	var clientWaitGroup sync.WaitGroup
+	clientWaitGroup.Add(1)
+	go func() {
+		defer clientWaitGroup.Done()
+		client.reconnect(ctx, handle, a...)
+	}()
+	return &clientWaitGroup
+}
+
+func (client *Client) reconnect(ctx context.Context, handle func(*Conn, ...interface{}), a ...interface{}) {
+	backoff := client.backoff.withDefaults()
+
+	attempt := 0
+	for ctx.Err() == nil {
+		client.setState(StateConnecting, nil)
+		conn, err := client.Dial(ctx)
+		if err == nil {
+			client.setState(StateConnected, nil)
+			connected := time.Now()
+			client.serveUntilDone(ctx, conn, handle, a...)
+
+			//A server that accepts and closes right away, for example because it is overloaded,
+			//must not be redialed at the initial delay forever.
+			if time.Since(connected) >= backoff.Max {
+				attempt = 0
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		client.setState(StateBackingOff, err)
+		timer := time.NewTimer(backoff.delay(attempt))
+		attempt++
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	client.setState(StateStopped, ctx.Err())
+}
+
+//serveUntilDone runs handle for conn and closes conn when handle returns or ctx is done.
+func (client *Client) serveUntilDone(ctx context.Context, conn *Conn, handle func(*Conn, ...interface{}), a ...interface{}) {
+	handleDone := make(chan struct{})
+	defer close(handleDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handleDone:
+		}
+	}()
+
+	handle(conn, a...)
+	conn.Close()
+}
+
+func (client *Client) setState(state ConnState, err error) {
+	if client.onStateChange != nil {
+		client.onStateChange(state, err)
+	}
+}
@@ -0,0 +1,150 @@
+package sc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 10 * time.Millisecond},
+		{attempt: 1, expected: 20 * time.Millisecond},
+		{attempt: 2, expected: 40 * time.Millisecond},
+		{attempt: 3, expected: 50 * time.Millisecond},
+		{attempt: 100, expected: 50 * time.Millisecond},
+	}
+
+	for _, tC := range testCases {
+		if d := backoff.delay(tC.attempt); d != tC.expected {
+			t.Fatalf("Attempt %d: Expected %s, got %s", tC.attempt, tC.expected, d)
+		}
+	}
+}
+
+func TestBackoffPartial(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		backoff  Backoff
+		attempt  int
+		expected time.Duration
+	}{
+		{desc: "no max", backoff: Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, attempt: 3, expected: 800 * time.Millisecond},
+		{desc: "no multiplier", backoff: Backoff{Initial: 100 * time.Millisecond, Max: time.Second}, attempt: 1, expected: 200 * time.Millisecond},
+		{desc: "no initial", backoff: Backoff{Max: time.Second, Multiplier: 3}, attempt: 1, expected: 300 * time.Millisecond},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			if d := tC.backoff.withDefaults().delay(tC.attempt); d != tC.expected {
+				t.Fatalf("Expected %s, got %s", tC.expected, d)
+			}
+		})
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	port := freePort(t)
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), port, time.Second, 1024)
+	client.SetBackoff(Backoff{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond, Multiplier: 2})
+
+	var mu sync.Mutex
+	states := make(map[ConnState]int)
+	backingOff := make(chan struct{}, 1)
+	client.OnStateChange(func(state ConnState, err error) {
+		mu.Lock()
+		states[state]++
+		mu.Unlock()
+		if state == StateBackingOff {
+			select {
+			case backingOff <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	connected := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	clientWaitGroup := client.ConnectReconnecting(ctx, func(conn *Conn, a ...interface{}) {
+		connected <- struct{}{}
+		conn.Read(make([]byte, 1))
+	})
+
+	//The server is not up yet, so the client has to back off first.
+	<-backingOff
+	server := NewTCPServer(port, 0, 1024, 0)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-connected:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Client did not reconnect")
+		}
+	}
+
+	cancel()
+	clientWaitGroup.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if states[StateBackingOff] == 0 || states[StateConnected] < 3 || states[StateStopped] != 1 {
+		t.Fatalf("Unexpected state transitions %v", states)
+	}
+}
+
+func TestClientReconnectBacksOffOnImmediateClose(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	server.SetLogger(NopLogger)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	client := NewTCPClient(net.IPv4(127, 0, 0, 1), addrPort(server.Addr()), time.Second, 1024)
+	client.SetLogger(NopLogger)
+	client.SetBackoff(Backoff{Initial: 10 * time.Millisecond, Max: time.Second, Multiplier: 2})
+
+	connected := make(chan time.Time, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	clientWaitGroup := client.ConnectReconnecting(ctx, func(conn *Conn, a ...interface{}) {
+		connected <- time.Now()
+		conn.Read(make([]byte, 1))
+	})
+	defer clientWaitGroup.Wait()
+	defer cancel()
+
+	//The delays grow 10ms, 20ms, 40ms, 80ms although every dial succeeds.
+	var times []time.Time
+	for len(times) < 5 {
+		select {
+		case at := <-connected:
+			times = append(times, at)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Client did not reconnect")
+		}
+	}
+	if gap := times[4].Sub(times[3]); gap < 60*time.Millisecond {
+		t.Fatalf("Expected the backoff to grow for connections closed right away, last gap was %s", gap)
+	}
+}
@@ -0,0 +1,117 @@
+package sc
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//ConnInfo is a snapshot of a connection of a server.
+type ConnInfo struct {
+	ID           uint64
+	RemoteAddr   net.Addr
+	LocalAddr    net.Addr
+	Start        time.Time
+	BytesRead    int64
+	BytesWritten int64
+	//LastSeen is the last time data was received, RTT the last heartbeat round-trip time.
+	LastSeen time.Time
+	RTT      time.Duration
+}
+
+//Connections returns a snapshot of all open connections of the server ordered by id.
+func (server *Server) Connections() []ConnInfo {
+	conns := server.connList()
+	infos := make([]ConnInfo, len(conns))
+	for i, conn := range conns {
+		infos[i] = conn.Info()
+	}
+	return infos
+}
+
+//Kill closes the connection with id. The handler of the connection sees the closed connection on its next read or write.
+//Returns ErrUnknownConn if there is no open connection with id.
+func (server *Server) Kill(id uint64) error {
+	server.connsMu.Lock()
+	conn, ok := server.conns[id]
+	server.connsMu.Unlock()
+
+	if !ok {
+		return ErrUnknownConn
+	}
+	return conn.Close()
+}
+
+//defaultBroadcastTimeout bounds the writes of Broadcast if neither the broadcast timeout
+//nor the write timeout of a connection is set.
+const defaultBroadcastTimeout = 10 * time.Second
+
+//SetBroadcastTimeout sets the time a write of Broadcast may take on connections without write timeout.
+//Connections whose peer does not take the message in time are closed, so one stuck peer can not block Broadcast.
+//Defaults to 10 seconds.
+func (server *Server) SetBroadcastTimeout(timeout time.Duration) {
+	server.broadcastTimeout = timeout
+}
+
+//Broadcast sends msg with conn.WriteMessage to all open connections for which filter returns true.
+//A nil filter selects all connections. The messages are written concurrently.
+//The writes are bound by the write timeout of the connections or the broadcast timeout, see SetBroadcastTimeout.
+//Returns the amount of connections msg was sent to and a *BroadcastError if sending failed for any connection.
+func (server *Server) Broadcast(msg []byte, filter func(ConnInfo) bool) (int, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sent := 0
+	failed := make(map[uint64]error)
+
+	timeout := server.broadcastTimeout
+	if timeout <= 0 {
+		timeout = defaultBroadcastTimeout
+	}
+
+	for _, conn := range server.connList() {
+		if filter != nil && !filter(conn.Info()) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(conn *Conn) {
+			defer wg.Done()
+			var err error
+			if conn.WriteTimeout() > 0 {
+				err = conn.WriteMessage(msg)
+			} else {
+				err = conn.writeMessageWithin(msg, timeout)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[conn.id] = err
+				return
+			}
+			sent++
+		}(conn)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return sent, &BroadcastError{Failed: failed}
+	}
+	return sent, nil
+}
+
+//connList returns the open connections ordered by id.
+func (server *Server) connList() []*Conn {
+	server.connsMu.Lock()
+	conns := make([]*Conn, 0, len(server.conns))
+	for _, conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.connsMu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].id < conns[j].id
+	})
+	return conns
+}
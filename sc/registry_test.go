@@ -0,0 +1,103 @@
+package sc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerRegistry(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		defer conn.Close()
+		for {
+			if _, err := conn.Read(make([]byte, 1)); err != nil {
+				return nil
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Shutdown(context.Background())
+
+	peers := make([]*Conn, 3)
+	for i := range peers {
+		netConn, err := net.Dial("tcp", loopback(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers[i] = NewConn(netConn, time.Second, 1024)
+		defer peers[i].Close()
+		waitForClients(t, server, int64(i+1))
+	}
+
+	infos := server.Connections()
+	if len(infos) != 3 || infos[0].ID != 1 || infos[2].ID != 3 {
+		t.Fatalf("Unexpected connections %+v", infos)
+	}
+	if infos[0].RemoteAddr.String() != peers[0].LocalAddr().String() {
+		t.Fatalf("Expected remote address %s, got %s", peers[0].LocalAddr(), infos[0].RemoteAddr)
+	}
+
+	sent, err := server.Broadcast([]byte("hello"), func(info ConnInfo) bool { return info.ID != 2 })
+	if err != nil || sent != 2 {
+		t.Fatalf("Expected broadcast to 2 connections, got %d (%v)", sent, err)
+	}
+	for _, i := range []int{0, 2} {
+		msg, err := peers[i].ReadMessage()
+		if err != nil || string(msg) != "hello" {
+			t.Fatalf("Peer %d: Expected hello, got %q (%v)", i, msg, err)
+		}
+	}
+
+	if err := server.Kill(2); err != nil {
+		t.Fatal(err)
+	}
+	waitForClients(t, server, 2)
+	if err := server.Kill(2); err != ErrUnknownConn {
+		t.Fatalf("Expected ErrUnknownConn, got %v", err)
+	}
+}
+
+func TestServerBroadcastStuckPeer(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	server.SetBroadcastTimeout(50 * time.Millisecond)
+	wg, err := server.StartHandler(HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		<-ctx.Done()
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Shutdown(context.Background())
+
+	//The peer never reads, so the message can not fit into the socket buffers.
+	netConn, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	waitForClients(t, server, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Broadcast(make([]byte, 64<<20), nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var broadcastErr *BroadcastError
+		if !errors.As(err, &broadcastErr) || !errors.Is(broadcastErr.Failed[1], ErrTimeout) {
+			t.Fatalf("Expected timed out broadcast, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Broadcast blocked on a peer that does not read")
+	}
+}
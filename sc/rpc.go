@@ -0,0 +1,315 @@
+package sc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type rpcKind int
+
+const (
+	rpcRequest rpcKind = iota
+	rpcResponse
+	rpcCancel
+)
+
+//rpcMessage is the envelope of every rpc frame. Bodies are encoded as JSON.
+type rpcMessage struct {
+	ID     uint64          `json:"id"`
+	Kind   rpcKind         `json:"kind"`
+	Method string          `json:"method,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+//RPCHandlerFunc handles a single call of a registered method.
+//req is the JSON encoded request of the caller. The returned value is JSON encoded and sent back to the caller.
+//A returned error or a panic is sent to the caller as *RemoteError. ctx is cancelled if the caller cancels the call
+//or the connection is closed.
+type RPCHandlerFunc func(ctx context.Context, req json.RawMessage) (interface{}, error)
+
+//RPCServer dispatches calls of RPCClients to registered methods.
+//RPCServer is a Handler, pass it to Server.StartHandler or pass RPCServer.Handle to Server.Start to serve it.
+type RPCServer struct {
+	mu      sync.RWMutex
+	methods map[string]RPCHandlerFunc
+}
+
+//NewRPCServer is the constructor for an RPCServer without any methods.
+func NewRPCServer() *RPCServer {
+	return &RPCServer{methods: make(map[string]RPCHandlerFunc)}
+}
+
+//Register registers handle for method. A handler that is registered for method already is replaced.
+func (rpcServer *RPCServer) Register(method string, handle RPCHandlerFunc) {
+	rpcServer.mu.Lock()
+	defer rpcServer.mu.Unlock()
+	rpcServer.methods[method] = handle
+}
+
+func (rpcServer *RPCServer) method(method string) (RPCHandlerFunc, bool) {
+	rpcServer.mu.RLock()
+	defer rpcServer.mu.RUnlock()
+	handle, ok := rpcServer.methods[method]
+	return handle, ok
+}
+
+//Handle serves rpc calls on conn until the connection is closed. Every call is handled in its own routine,
+//so many calls can be in flight on the same connection. Handle closes conn before returning.
+func (rpcServer *RPCServer) Handle(conn *Conn, a ...interface{}) {
+	err := rpcServer.serve(conn)
+	if err != nil {
+		conn.log(Event{Kind: EventError, Message: "malformed rpc message", Err: err})
+	}
+}
+
+//ServeConn implements Handler. It serves rpc calls on conn like Handle, but returns the error of a malformed message.
+func (rpcServer *RPCServer) ServeConn(ctx context.Context, conn *Conn) error {
+	return rpcServer.serve(conn)
+}
+
+//serve serves rpc calls on conn until the connection is closed or a malformed message is received.
+func (rpcServer *RPCServer) serve(conn *Conn) error {
+	defer conn.Close()
+
+	var callWaitGroup sync.WaitGroup
+	var mu sync.Mutex
+	calls := make(map[uint64]context.CancelFunc)
+
+	defer func() {
+		mu.Lock()
+		for _, cancel := range calls {
+			cancel()
+		}
+		mu.Unlock()
+		callWaitGroup.Wait()
+	}()
+
+	for {
+		b, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+
+		var msg rpcMessage
+		err = json.Unmarshal(b, &msg)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Kind {
+		case rpcCancel:
+			mu.Lock()
+			if cancel, ok := calls[msg.ID]; ok {
+				cancel()
+			}
+			mu.Unlock()
+
+		case rpcRequest:
+			ctx, cancel := context.WithCancel(conn.Context())
+			mu.Lock()
+			calls[msg.ID] = cancel
+			mu.Unlock()
+
+			callWaitGroup.Add(1)
+			go func(msg rpcMessage) {
+				defer callWaitGroup.Done()
+				defer func() {
+					mu.Lock()
+					delete(calls, msg.ID)
+					mu.Unlock()
+					cancel()
+				}()
+
+				resp := rpcServer.call(ctx, conn, &msg)
+				b, err := json.Marshal(resp)
+				if err != nil {
+					conn.log(Event{Kind: EventError, Message: "encoding rpc response for " + msg.Method, Err: err})
+					return
+				}
+				err = conn.WriteMessage(b)
+				if err != nil {
+					conn.log(Event{Kind: EventError, Message: "sending rpc response for " + msg.Method, Err: err})
+				}
+			}(msg)
+		}
+	}
+}
+
+//call runs the handler of req and builds the response message. A panicking handler is logged on conn
+//and answered with an error, so it takes down neither the connection nor the process.
+func (rpcServer *RPCServer) call(ctx context.Context, conn *Conn, req *rpcMessage) (resp *rpcMessage) {
+	resp = &rpcMessage{ID: req.ID, Kind: rpcResponse}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+		conn.log(Event{Kind: EventHandlerPanic, Message: string(panicErr.Stack), Err: panicErr})
+		resp.Body = nil
+		resp.Error = panicErr.Error()
+	}()
+
+	handle, ok := rpcServer.method(req.Method)
+	if !ok {
+		resp.Error = fmt.Sprintf("unknown method %q", req.Method)
+		return resp
+	}
+
+	v, err := handle(ctx, req.Body)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+
+	resp.Body, err = json.Marshal(v)
+	if err != nil {
+		resp.Error = fmt.Sprintf("encoding response: %s", err)
+	}
+	return resp
+}
+
+//RPCClient calls methods of an RPCServer. Calls are multiplexed over a single connection,
+//every call is identified by a correlation id. RPCClient is safe for concurrent use.
+type RPCClient struct {
+	conn    *Conn
+	timeout time.Duration
+	nextID  uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan *rpcMessage
+	err     error
+	done    chan struct{}
+}
+
+//NewRPCClient is the constructor for an RPCClient using conn. It starts reading responses from conn right away,
+//so conn must not be read from by anyone else.
+//timeout is the default timeout of each call, a timeout of 0 means that only the context passed to Call applies.
+func NewRPCClient(conn *Conn, timeout time.Duration) *RPCClient {
+	rpcClient := &RPCClient{conn: conn,
+		timeout: timeout,
+		pending: make(map[uint64]chan *rpcMessage),
+		done:    make(chan struct{})}
+	go rpcClient.readResponses()
+	return rpcClient
+}
+
+//Call calls method on the server with req and decodes the response into resp.
+//req and resp are encoded as JSON, resp may be nil if the response is not of interest.
+//If the call fails on the server side, a *RemoteError is returned.
+//If ctx is done or the default timeout of the client passes first, the call is cancelled on the server and ctx.Err() is returned.
+func (rpcClient *RPCClient) Call(ctx context.Context, method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if rpcClient.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rpcClient.timeout)
+		defer cancel()
+	}
+
+	id := atomic.AddUint64(&rpcClient.nextID, 1)
+	respChan := make(chan *rpcMessage, 1)
+
+	rpcClient.mu.Lock()
+	if rpcClient.err != nil {
+		rpcClient.mu.Unlock()
+		return rpcClient.err
+	}
+	rpcClient.pending[id] = respChan
+	rpcClient.mu.Unlock()
+	defer rpcClient.removePending(id)
+
+	err = rpcClient.send(&rpcMessage{ID: id, Kind: rpcRequest, Method: method, Body: body})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case msg := <-respChan:
+		if msg.Error != "" {
+			return &RemoteError{Method: method, Message: msg.Error}
+		}
+		if resp == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Body, resp)
+
+	case <-ctx.Done():
+		//Best effort, the server drops responses of cancelled calls anyway.
+		rpcClient.send(&rpcMessage{ID: id, Kind: rpcCancel})
+		return ctx.Err()
+
+	case <-rpcClient.done:
+		return rpcClient.err
+	}
+}
+
+//Close closes the connection of the client. Pending calls return ErrRPCClientClosed.
+func (rpcClient *RPCClient) Close() error {
+	rpcClient.fail(ErrRPCClientClosed)
+	return rpcClient.conn.Close()
+}
+
+func (rpcClient *RPCClient) send(msg *rpcMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return rpcClient.conn.WriteMessage(b)
+}
+
+func (rpcClient *RPCClient) removePending(id uint64) {
+	rpcClient.mu.Lock()
+	delete(rpcClient.pending, id)
+	rpcClient.mu.Unlock()
+}
+
+//readResponses dispatches responses to the pending calls until the connection fails.
+func (rpcClient *RPCClient) readResponses() {
+	for {
+		b, err := rpcClient.conn.ReadMessage()
+		if err != nil {
+			rpcClient.fail(&RPCConnError{Err: err})
+			return
+		}
+
+		var msg rpcMessage
+		err = json.Unmarshal(b, &msg)
+		if err != nil {
+			rpcClient.fail(&RPCConnError{Err: err})
+			rpcClient.conn.Close()
+			return
+		}
+
+		//Every call gets at most one response, so a repeated id or a caller that gave up can not block reading.
+		rpcClient.mu.Lock()
+		respChan, ok := rpcClient.pending[msg.ID]
+		delete(rpcClient.pending, msg.ID)
+		rpcClient.mu.Unlock()
+		if ok {
+			respChan <- &msg
+		}
+	}
+}
+
+//fail marks the client as unusable. Only the first error is kept.
+func (rpcClient *RPCClient) fail(err error) {
+	rpcClient.mu.Lock()
+	defer rpcClient.mu.Unlock()
+
+	if rpcClient.err != nil {
+		return
+	}
+	rpcClient.err = err
+	close(rpcClient.done)
+}
@@ -0,0 +1,144 @@
+package sc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRPC(t *testing.T) (*RPCClient, chan struct{}, func()) {
+	cancelled := make(chan struct{}, 1)
+
+	rpcServer := NewRPCServer()
+	rpcServer.Register("add", func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+		var operands [2]int
+		if err := json.Unmarshal(req, &operands); err != nil {
+			return nil, err
+		}
+		return operands[0] + operands[1], nil
+	})
+	rpcServer.Register("fail", func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	rpcServer.Register("panic", func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+		panic("kaboom")
+	})
+	rpcServer.Register("block", func(ctx context.Context, req json.RawMessage) (interface{}, error) {
+		<-ctx.Done()
+		cancelled <- struct{}{}
+		return nil, ctx.Err()
+	})
+
+	a, b := net.Pipe()
+	serverConn := NewConn(a, 0, 1024)
+	serverConn.SetLogger(NopLogger)
+	go rpcServer.Handle(serverConn)
+	rpcClient := NewRPCClient(NewConn(b, 0, 1024), 0)
+	return rpcClient, cancelled, func() { rpcClient.Close() }
+}
+
+func TestRPCConcurrentCalls(t *testing.T) {
+	rpcClient, _, closeRPC := newTestRPC(t)
+	defer closeRPC()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			if err := rpcClient.Call(context.Background(), "add", [2]int{i, i}, &sum); err != nil {
+				t.Error(err)
+				return
+			}
+			if sum != 2*i {
+				t.Errorf("Expected %d, got %d", 2*i, sum)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRPCRemoteError(t *testing.T) {
+	rpcClient, _, closeRPC := newTestRPC(t)
+	defer closeRPC()
+
+	testCases := []struct {
+		desc    string
+		method  string
+		message string
+	}{
+		{desc: "Handler error", method: "fail", message: "boom"},
+		{desc: "Unknown method", method: "missing", message: `unknown method "missing"`},
+		{desc: "Handler panic", method: "panic", message: "Handler panicked: kaboom"},
+	}
+
+	for _, tC := range testCases {
+		err := rpcClient.Call(context.Background(), tC.method, nil, nil)
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) {
+			t.Fatalf("%s: Expected *RemoteError, got %v", tC.desc, err)
+		}
+		if remoteErr.Message != tC.message {
+			t.Fatalf("%s: Expected message %q, got %q", tC.desc, tC.message, remoteErr.Message)
+		}
+	}
+}
+
+func TestRPCCancellation(t *testing.T) {
+	rpcClient, cancelled, closeRPC := newTestRPC(t)
+	defer closeRPC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := rpcClient.Call(ctx, "block", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Call was not cancelled on the server")
+	}
+}
+
+func TestRPCDuplicateResponses(t *testing.T) {
+	a, b := net.Pipe()
+	server := NewConn(a, 0, 1024)
+	defer server.Close()
+	rpcClient := NewRPCClient(NewConn(b, 0, 1024), time.Second)
+	defer rpcClient.Close()
+
+	//The server answers every request three times, the surplus responses must be dropped.
+	go func() {
+		for {
+			b, err := server.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpcMessage
+			if err := json.Unmarshal(b, &req); err != nil {
+				return
+			}
+			resp, _ := json.Marshal(&rpcMessage{ID: req.ID, Kind: rpcResponse, Body: json.RawMessage("1")})
+			for i := 0; i < 3; i++ {
+				if err := server.WriteMessage(resp); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		var v int
+		if err := rpcClient.Call(context.Background(), "one", nil, &v); err != nil || v != 1 {
+			t.Fatalf("Call %d: Expected 1, got %d (%v)", i, v, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//Package sctest runs sc handlers over in-memory connections for tests.
+//A Harness starts a server on a sc.MemListener, dials clients to it and records the outcome of every handler run.
+package sctest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/beeemT/Packages/sc"
+)
+
+//Outcome is the result of a single handler run.
+type Outcome struct {
+	//ConnID is the id of the served connection.
+	ConnID uint64
+	//Err is the error returned by the handler or a *sc.PanicError if it panicked.
+	Err error
+}
+
+//Harness serves a handler on an in-memory listener.
+type Harness struct {
+	Server   *sc.Server
+	Listener *sc.MemListener
+
+	serverWaitGroup *sync.WaitGroup
+
+	mu       sync.Mutex
+	outcomes []Outcome
+	//recorded is signalled after every recorded outcome.
+	recorded chan struct{}
+}
+
+//Start starts a tcp server without connection limit or timeouts serving handler on a new MemListener.
+//The server does not log.
+func Start(handler sc.Handler) (*Harness, error) {
+	server := sc.NewTCPServer(0, 0, 1<<20, 0)
+	server.SetLogger(sc.NopLogger)
+	return StartServer(server, handler)
+}
+
+//StartServer starts the configured server serving handler on a new MemListener.
+//The listeners and the error handler of server are replaced by the harness.
+func StartServer(server *sc.Server, handler sc.Handler) (*Harness, error) {
+	harness := &Harness{Server: server,
+		Listener: sc.NewMemListener("sctest"),
+		recorded: make(chan struct{}, 1)}
+
+	server.SetListeners(harness.Listener)
+	server.SetErrorHandler(func(conn *sc.Conn, err error) {
+		//Handlers that returned are recorded by the wrapper below, only panics are left.
+		var panicErr *sc.PanicError
+		if errors.As(err, &panicErr) {
+			harness.record(conn, err)
+		}
+	})
+
+	wg, err := server.StartHandler(sc.HandlerFunc(func(ctx context.Context, conn *sc.Conn) error {
+		err := handler.ServeConn(ctx, conn)
+		harness.record(conn, err)
+		return err
+	}))
+	if err != nil {
+		return nil, err
+	}
+	harness.serverWaitGroup = wg
+	return harness, nil
+}
+
+//Client returns a client dialing the in-memory listener of the harness.
+func (harness *Harness) Client() *sc.Client {
+	client := sc.NewTCPClient(nil, 0, 0, 1<<20)
+	client.SetLogger(sc.NopLogger)
+	client.SetDialer(harness.Listener.DialContext)
+	return client
+}
+
+//Dial connects a new client to the server.
+func (harness *Harness) Dial(ctx context.Context) (*sc.Conn, error) {
+	return harness.Client().Dial(ctx)
+}
+
+//DialN connects n clients to the server one after another, so the connection ids of the server follow the order of the returned connections.
+//On failure the already established connections are closed.
+func (harness *Harness) DialN(ctx context.Context, n int) ([]*sc.Conn, error) {
+	conns := make([]*sc.Conn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := harness.Dial(ctx)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+//Wait blocks until n handler runs finished or ctx is done and returns the outcomes ordered by connection id.
+func (harness *Harness) Wait(ctx context.Context, n int) ([]Outcome, error) {
+	for {
+		outcomes := harness.Outcomes()
+		if len(outcomes) >= n {
+			return outcomes, nil
+		}
+
+		select {
+		case <-harness.recorded:
+		case <-ctx.Done():
+			return outcomes, ctx.Err()
+		}
+	}
+}
+
+//Outcomes returns the outcomes recorded so far ordered by connection id.
+func (harness *Harness) Outcomes() []Outcome {
+	harness.mu.Lock()
+	defer harness.mu.Unlock()
+
+	outcomes := make([]Outcome, len(harness.outcomes))
+	copy(outcomes, harness.outcomes)
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].ConnID < outcomes[j].ConnID
+	})
+	return outcomes
+}
+
+//Close shuts the server down, waits for all handlers and returns their outcomes ordered by connection id.
+func (harness *Harness) Close(ctx context.Context) ([]Outcome, error) {
+	err := harness.Server.Shutdown(ctx)
+	harness.serverWaitGroup.Wait()
+	return harness.Outcomes(), err
+}
+
+func (harness *Harness) record(conn *sc.Conn, err error) {
+	harness.mu.Lock()
+	harness.outcomes = append(harness.outcomes, Outcome{ConnID: conn.ID(), Err: err})
+	harness.mu.Unlock()
+
+	select {
+	case harness.recorded <- struct{}{}:
+	default:
+	}
+}
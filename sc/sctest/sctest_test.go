@@ -0,0 +1,67 @@
+package sctest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/beeemT/Packages/sc"
+)
+
+func TestHarness(t *testing.T) {
+	errOdd := errors.New("odd message")
+
+	harness, err := Start(sc.HandlerFunc(func(ctx context.Context, conn *sc.Conn) error {
+		defer conn.Close()
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch string(msg) {
+		case "panic":
+			panic("boom")
+		case "odd":
+			return errOdd
+		}
+		return conn.WriteMessage(msg)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conns, err := harness.DialN(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, msg := range []string{"even", "odd", "panic"} {
+		if err := conns[i].WriteMessage([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg, err := conns[0].ReadMessage(); err != nil || string(msg) != "even" {
+		t.Fatalf("Expected echo, got %q (%v)", msg, err)
+	}
+
+	outcomes, err := harness.Wait(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var panicErr *sc.PanicError
+	if outcomes[0].ConnID != 1 || outcomes[0].Err != nil ||
+		outcomes[1].ConnID != 2 || outcomes[1].Err != errOdd ||
+		outcomes[2].ConnID != 3 || !errors.As(outcomes[2].Err, &panicErr) {
+		t.Fatalf("Unexpected outcomes %+v", outcomes)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if _, err := harness.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
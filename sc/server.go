@@ -1,9 +1,14 @@
 package sc
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"os"
+	"runtime/debug"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -15,86 +20,367 @@ type Server struct {
 	port           int
 	defaultTimeout time.Duration
 
+	//readTimeout and writeTimeout are the defaults for all accepted connections.
+	readTimeout, writeTimeout time.Duration
+
+	defaultMaxReadBuffer, curClients int64
+	sigchan                          chan struct{}
+	proto                            protocol
+
+	//admission limits the amount of concurrently served clients to maxClients.
 	//maxClients <= 0 means no restriction in client count.
-	defaultMaxReadBuffer, maxClients, curClients int64
-	sigchan                                      chan struct{}
-	proto                                        protocol
+	admission      *admission
+	overloadPolicy OverloadPolicy
+	goodbye        []byte
+	rejected       int64
+
+	//tlsConfig is nil for plain text servers.
+	tlsConfig *tls.Config
+
+	//path and perm are only used by unix domain socket servers.
+	path string
+	perm os.FileMode
+
+	//filters decide whether a new connection is served.
+	filters  []ConnFilter
+	filtered int64
+
+	metrics *serverMetrics
+
+	//middleware wraps the Handler passed to Start and StartHandler.
+	middleware []Middleware
+
+	//packetIdleTimeout is the time after which idle udp sessions are closed.
+	packetIdleTimeout time.Duration
+
+	//broadcastTimeout bounds the writes of Broadcast on connections without write timeout.
+	broadcastTimeout time.Duration
+
+	//heartbeat is started on every accepted connection if its interval is set.
+	heartbeat HeartbeatConfig
+
+	//logger receives the events of the server and its connections.
+	logger Logger
+
+	//compression is negotiated with every client if methods are set.
+	compression CompressionConfig
+
+	//errorHandler receives the errors and recovered panics of handlers.
+	errorHandler func(conn *Conn, err error)
+
+	//proxyTrusted are the load balancers whose connections start with a PROXY header.
+	proxyTrusted []*net.IPNet
+	proxyTimeout time.Duration
+
+	//ctx is the parent of all connection contexts. It is cancelled on Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	connWaitGroup sync.WaitGroup
+
+	//bindIPs are the addresses to listen on, all addresses if empty.
+	bindIPs []net.IP
+	//listeners are served instead of binding the addresses of the server if set.
+	listeners []net.Listener
+
+	//connsMu guards conns, nextConnID, stopped, addrs and sockets.
+	connsMu    sync.Mutex
+	conns      map[uint64]*Conn
+	nextConnID uint64
+	stopped    bool
+	//addrs are the addresses of the bound listeners, sockets the listeners of stream based servers.
+	addrs   []net.Addr
+	sockets []net.Listener
+
+	//handedOff is set once the sockets were passed to another process by Handoff. It is accessed atomically.
+	handedOff int32
 }
 
 //NewServer is the constructor for a server.
-//A defaultTimeout of 0 means no timeouts.
-//Timeouts have to be implemented by the programmer in the handle method that is passed to server.Start.
+//defaultTimeout is the idle timeout of every accepted connection. A defaultTimeout of 0 means no timeouts.
+//The timeouts are applied automatically on every read and write, see NewConn.
 //For limited reading use conn.LimitedRead in the handle method.
 //A maxClients value of 0 or lower causes the server to accept all incoming connections.
 func NewServer(port int, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64, proto protocol) *Server {
 	sigchan := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{port: port,
 		defaultTimeout:       defaultTimeout,
 		defaultMaxReadBuffer: defaultMaxReadBuffer,
-		maxClients:           maxClients,
+		admission:            newAdmission(maxClients),
+		metrics:              newServerMetrics(),
+		logger:               defaultLogger,
 		sigchan:              sigchan,
-		proto:                proto}
+		proto:                proto,
+		ctx:                  ctx,
+		cancel:               cancel,
+		conns:                make(map[uint64]*Conn)}
 }
 
 //NewTCPServer is the constructor for a server with the protocol prefilled.
 func NewTCPServer(port int, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
-	sigchan := make(chan struct{})
-	return &Server{port: port,
-		defaultTimeout:       defaultTimeout,
-		defaultMaxReadBuffer: defaultMaxReadBuffer,
-		maxClients:           maxClients,
-		sigchan:              sigchan,
-		proto:                tcp}
+	return NewServer(port, defaultTimeout, defaultMaxReadBuffer, maxClients, tcp)
 }
 
 //NewUDPServer is the constructor for a server with the protocol prefilled.
+//Datagrams are demultiplexed by remote address into virtual connections, so every peer gets its own *Conn
+//and its own call of the handle function. Every conn.Read returns exactly one datagram and every conn.Write sends one.
+//Sessions without traffic are closed after the packet idle timeout, see SetPacketIdleTimeout.
+//A session is closed as well when its handle function returns.
 func NewUDPServer(port int, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
-	sigchan := make(chan struct{})
-	return &Server{port: port,
-		defaultTimeout:       defaultTimeout,
-		defaultMaxReadBuffer: defaultMaxReadBuffer,
-		maxClients:           maxClients,
-		sigchan:              sigchan,
-		proto:                udp}
+	return NewServer(port, defaultTimeout, defaultMaxReadBuffer, maxClients, udp)
+}
+
+//NewTLSServer is the constructor for a tcp server that serves all connections over TLS.
+//tlsConfig has to contain at least one certificate. The TLS handshake is done before the handle function is called,
+//so conn.ConnectionState, conn.PeerCertificate and conn.CipherSuite can be used directly in the handle function.
+func NewTLSServer(port int, tlsConfig *tls.Config, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
+	server := NewServer(port, defaultTimeout, defaultMaxReadBuffer, maxClients, tcp)
+	server.tlsConfig = tlsConfig
+	return server
+}
+
+//NewMutualTLSServer is the constructor for a TLS server that requires and verifies client certificates against clientCAs.
+//tlsConfig is cloned before ClientAuth and ClientCAs are set, so the passed config is not modified.
+func NewMutualTLSServer(port int, tlsConfig *tls.Config, clientCAs *x509.CertPool, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = clientCAs
+	return NewTLSServer(port, tlsConfig, defaultTimeout, defaultMaxReadBuffer, maxClients)
+}
+
+//NewUnixServer is the constructor for a server listening on the unix domain socket at path.
+//A stale socket file at path is removed on start. If another server still listens on path, the server does not start.
+//The permissions of the socket file are set to perm after binding. A perm of 0 keeps the default permissions.
+//Use conn.PeerCredentials in the handle function to authorize the local peer.
+func NewUnixServer(path string, perm os.FileMode, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
+	server := NewServer(0, defaultTimeout, defaultMaxReadBuffer, maxClients, unix)
+	server.path = path
+	server.perm = perm
+	return server
+}
+
+//NewUnixPacketServer is the constructor for a server listening on a sequenced packet unix domain socket at path.
+//Message boundaries are preserved, every conn.Read returns at most one message.
+//See NewUnixServer for the handling of path and perm.
+func NewUnixPacketServer(path string, perm os.FileMode, defaultTimeout time.Duration, defaultMaxReadBuffer, maxClients int64) *Server {
+	server := NewUnixServer(path, perm, defaultTimeout, defaultMaxReadBuffer, maxClients)
+	server.proto = unixpacket
+	return server
 }
 
 //CurClients is the getter for server.curClients.
-func (server Server) CurClients() int64 {
-	return server.curClients
+func (server *Server) CurClients() int64 {
+	return atomic.LoadInt64(&server.curClients)
 }
 
 //MaxClients is the getter for server.maxClients.
-func (server Server) MaxClients() int64 {
-	return server.maxClients
+func (server *Server) MaxClients() int64 {
+	return server.admission.limit()
 }
 
-//SetMaxClients is the setter for server.maxClients.
+//SetMaxClients is the setter for server.maxClients. It can be changed while the server is running.
 func (server *Server) SetMaxClients(maxClients int64) {
-	server.maxClients = maxClients
+	server.admission.setLimit(maxClients)
+}
+
+//SetReadTimeout sets the default read timeout of accepted connections. Has to be called before Start.
+//It can be overridden per connection with conn.SetReadTimeout.
+func (server *Server) SetReadTimeout(timeout time.Duration) {
+	server.readTimeout = timeout
+}
+
+//SetWriteTimeout sets the default write timeout of accepted connections. Has to be called before Start.
+//It can be overridden per connection with conn.SetWriteTimeout.
+func (server *Server) SetWriteTimeout(timeout time.Duration) {
+	server.writeTimeout = timeout
+}
+
+//PacketIdleTimeout is the getter for the idle timeout of udp sessions.
+//If it is not set, the defaultTimeout of the server is used and if that is 0 as well, two minutes are used.
+func (server *Server) PacketIdleTimeout() time.Duration {
+	switch {
+	case server.packetIdleTimeout > 0:
+		return server.packetIdleTimeout
+	case server.defaultTimeout > 0:
+		return server.defaultTimeout
+	default:
+		return defaultPacketIdleTimeout
+	}
+}
+
+//SetPacketIdleTimeout is the setter for the idle timeout of udp sessions. Has to be called before Start.
+func (server *Server) SetPacketIdleTimeout(idleTimeout time.Duration) {
+	server.packetIdleTimeout = idleTimeout
+}
+
+//SetHeartbeat enables heartbeats with config on every accepted connection, see Conn.StartHeartbeat.
+//The clients have to enable heartbeats as well. Has to be called before Start.
+func (server *Server) SetHeartbeat(config HeartbeatConfig) {
+	server.heartbeat = config
+}
+
+//SetLogger sets the logger receiving the events of the server and its connections. Has to be called before Start.
+//The default logger writes to the standard logger of the log package, use NopLogger to silence the server.
+func (server *Server) SetLogger(logger Logger) {
+	server.logger = logger
+}
+
+//SetCompression enables the compression handshake on every accepted connection. Has to be called before Start.
+//Every client has to run the handshake as well, see Client.SetCompression. Clients offering none of config.Methods
+//stay uncompressed. udp and unixpacket servers ignore the compression.
+func (server *Server) SetCompression(config CompressionConfig) {
+	server.compression = config
+}
+
+//SetErrorHandler sets the callback receiving the errors returned by handlers and the *PanicError of recovered panics.
+//It is called from the routine of the connection after the handler returned. Has to be called before Start.
+func (server *Server) SetErrorHandler(errorHandler func(conn *Conn, err error)) {
+	server.errorHandler = errorHandler
+}
+
+//Use appends middleware to the middleware chain of the server. The first middleware is the outermost one.
+//Has to be called before Start.
+func (server *Server) Use(middleware ...Middleware) {
+	server.middleware = append(server.middleware, middleware...)
 }
 
 //Start boots the server. The server waits for calling s.Stop() for a graceful shut down.
+//Start binds all addresses of the server before it returns, so the server is ready to accept connections
+//once Start returned without an error. If binding fails, the error is returned and the server is not started.
 //Start returns the waitGroup for the server so the caller can wait for the server to finish.
 //The handle function has to handle the close of the passed connection itself.
-func (server *Server) Start(handle func(*Conn, ...interface{}), a ...interface{}) *sync.WaitGroup {
-	var serverWaitGroup, connWaitGroup sync.WaitGroup
-	serverWaitGroup.Add(1)
+func (server *Server) Start(handle func(*Conn, ...interface{}), a ...interface{}) (*sync.WaitGroup, error) {
+	return server.StartHandler(handleFunc(handle, a...))
+}
+
+//StartHandler boots the server like Start, but serves every connection with handler wrapped in the middleware of the server.
+func (server *Server) StartHandler(handler Handler) (*sync.WaitGroup, error) {
+	handler = Chain(handler, server.middleware...)
+	server.log(Event{Kind: EventServerStarting})
+
+	var serverWaitGroup sync.WaitGroup
+	if server.proto.packetBased() {
+		packetConns, err := server.bindPackets()
+		if err != nil {
+			server.log(Event{Kind: EventListenFailed, Err: err})
+			return nil, err
+		}
+		for _, packetConn := range packetConns {
+			serverWaitGroup.Add(1)
+			go server.listenAndServePackets(packetConn, &serverWaitGroup, handler)
+		}
+	} else {
+		sockets, err := server.bind()
+		if err != nil {
+			server.log(Event{Kind: EventListenFailed, Err: err})
+			return nil, err
+		}
+		server.connsMu.Lock()
+		server.sockets = sockets
+		server.connsMu.Unlock()
+		for _, socket := range sockets {
+			serverWaitGroup.Add(1)
+			go server.listenAndServe(socket, &serverWaitGroup, handler)
+		}
+	}
 
 	//Shutdown Routine.
+	serverWaitGroup.Add(1)
 	go func() {
 		defer serverWaitGroup.Done()
 		<-server.sigchan
-		cleanup(&connWaitGroup)
+		server.cleanup()
 	}()
 
-	serverWaitGroup.Add(1)
-	go server.listenAndServe(&serverWaitGroup, &connWaitGroup, handle, a...)
-	return &serverWaitGroup
+	server.log(Event{Kind: EventServerStarted, Message: fmt.Sprint(server.Addrs())})
+	err := notifyHandoffReady()
+	if err != nil {
+		server.log(Event{Kind: EventError, Message: "notifying parent of handoff", Err: err})
+	}
+	return &serverWaitGroup, nil
+}
+
+//SetBindIPs sets the addresses the server listens on, one listener is bound per ip. Has to be called before Start.
+//By default the server listens on all addresses. With port 0 all listeners share the ephemeral port of the first one.
+//Unix servers ignore the bind ips.
+func (server *Server) SetBindIPs(ips ...net.IP) {
+	server.bindIPs = ips
+}
+
+//SetListeners makes the server accept connections on listeners instead of binding its own addresses,
+//for example on a MemListener. The listeners are closed when the server stops. Has to be called before Start.
+//Packet based servers ignore the listeners.
+func (server *Server) SetListeners(listeners ...net.Listener) {
+	server.listeners = listeners
+}
+
+//Addr returns the address of the first listener of the server, or nil if the server is not started.
+//Use it to read back the port chosen by the system if the server was constructed with port 0.
+func (server *Server) Addr() net.Addr {
+	addrs := server.Addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+//Addrs returns the addresses of all listeners of the server in the order of the bind ips.
+func (server *Server) Addrs() []net.Addr {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+
+	addrs := make([]net.Addr, len(server.addrs))
+	copy(addrs, server.addrs)
+	return addrs
 }
 
 //Stop triggers the shut down of the server by closing the signal channel and triggering the cleanup.
+//The server stops accepting new connections and waits for all running handle functions to return.
+//Calling Stop more than once is a no-op.
 func (server *Server) Stop() {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+
+	if server.stopped {
+		return
+	}
+	server.stopped = true
 	close(server.sigchan)
+	server.admission.close()
+}
+
+//Shutdown stops accepting new connections and cancels the context of every open connection.
+//It then waits for the handle functions to return until ctx is done.
+//Connections that are still open at that point are force closed and a *ShutdownError
+//reporting the amount of killed connections is returned. It is returned as well if handlers are still running.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.Stop()
+	server.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		server.connWaitGroup.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	//Handlers of connections that are not listed yet, for example during the handshake, can still be running
+	//although no connection had to be killed, so only a drained server counts as shut down cleanly.
+	killed := server.closeConns()
+	if killed == 0 {
+		select {
+		case <-drained:
+			return nil
+		default:
+		}
+	}
+	return &ShutdownError{Killed: killed, Err: ctx.Err()}
 }
 
 //Sigchan gets the receiving part of the servers signal channel.
@@ -102,91 +388,448 @@ func (server *Server) Sigchan() <-chan struct{} {
 	return server.sigchan
 }
 
-//listenAndServe boots the server. Is designed to be called into a go routine.
-//connWaitGroup manages all instances of handle and thus all clients.
-func (server *Server) listenAndServe(serverWaitGroup, connWaitGroup *sync.WaitGroup, handle func(*Conn, ...interface{}), a ...interface{}) {
-	log.Println("Starting service ...")
+//bind binds a listener for every address of the server.
+//If one address can not be bound, all previously bound listeners are closed again.
+func (server *Server) bind() ([]net.Listener, error) {
+	if len(server.listeners) > 0 {
+		addrs := make([]net.Addr, len(server.listeners))
+		for i, listener := range server.listeners {
+			addrs[i] = listener.Addr()
+		}
+		server.setAddrs(addrs)
+		return server.listeners, nil
+	}
+
+	if server.proto.unixBased() {
+		err := removeStaleSocket(server.proto.String(), server.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sockets []net.Listener
+	closeAll := func() {
+		for _, socket := range sockets {
+			socket.Close()
+		}
+	}
+
+	addresses := server.addresses(server.port)
+	for i := 0; i < len(addresses); i++ {
+		var socket net.Listener
+		var err error
+		if server.proto.unixBased() && server.perm != 0 {
+			//The permissions have to be in place before anyone can connect.
+			socket, err = listenUnixPerm(server.proto.String(), addresses[i], server.perm)
+		} else {
+			socket, err = net.Listen(server.proto.String(), addresses[i])
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sockets = append(sockets, socket)
+
+		if i == 0 && server.port == 0 {
+			//All bind ips share the ephemeral port of the first listener.
+			addresses = server.addresses(addrPort(socket.Addr()))
+		}
+	}
+
+	addrs := make([]net.Addr, len(sockets))
+	for i, socket := range sockets {
+		addrs[i] = socket.Addr()
+	}
+	server.setAddrs(addrs)
+	return sockets, nil
+}
+
+//bindPackets is the counterpart of bind for packet based protocols.
+func (server *Server) bindPackets() ([]net.PacketConn, error) {
+	var packetConns []net.PacketConn
+	addresses := server.addresses(server.port)
+	for i := 0; i < len(addresses); i++ {
+		packetConn, err := net.ListenPacket(server.proto.String(), addresses[i])
+		if err != nil {
+			for _, packetConn := range packetConns {
+				packetConn.Close()
+			}
+			return nil, err
+		}
+		packetConns = append(packetConns, packetConn)
+
+		if i == 0 && server.port == 0 {
+			addresses = server.addresses(addrPort(packetConn.LocalAddr()))
+		}
+	}
+
+	addrs := make([]net.Addr, len(packetConns))
+	for i, packetConn := range packetConns {
+		addrs[i] = packetConn.LocalAddr()
+	}
+	server.setAddrs(addrs)
+	return packetConns, nil
+}
+
+func (server *Server) setAddrs(addrs []net.Addr) {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+	server.addrs = addrs
+}
+
+//listenAndServe accepts connections on serverSocket until the server is stopped. Is designed to be called into a go routine.
+//server.connWaitGroup manages all instances of handler and thus all clients.
+func (server *Server) listenAndServe(serverSocket net.Listener, serverWaitGroup *sync.WaitGroup, handler Handler) {
 	defer serverWaitGroup.Done()
 
-	closeFlag := false
-	connChan := make(chan *net.Conn, 10)
+	serverWaitGroup.Add(1)
+	go server.listen(serverSocket, handler, serverWaitGroup)
 
-	serverSocket, err := net.Listen(server.proto.String(), fmt.Sprintf(":%s", strconv.Itoa(server.port)))
-	if err != nil {
-		log.Printf("Failed at establishing serverSocket: %s\n", err.Error())
+	<-server.sigchan
+	//Handoff closes the sockets itself.
+	if atomic.LoadInt32(&server.handedOff) == 1 {
 		return
 	}
+	err := serverSocket.Close()
+	if err != nil {
+		server.log(Event{Kind: EventError, Message: "closing listener", Err: err})
+	}
+}
 
-	defer func() {
-		err = serverSocket.Close()
-		if err != nil {
-			if closeFlag {
+//listenAndServePackets is the counterpart of listenAndServe for packet based protocols.
+//It reads datagrams from a single socket and dispatches them to the session of the sending peer.
+//A new session and handler routine is spawned for every unknown peer.
+func (server *Server) listenAndServePackets(packetConn net.PacketConn, serverWaitGroup *sync.WaitGroup, handler Handler) {
+	defer serverWaitGroup.Done()
+
+	sessions := newPacketSessions(packetConn)
+	idleTimeout := server.PacketIdleTimeout()
+
+	serverWaitGroup.Add(1)
+	go func() {
+		defer serverWaitGroup.Done()
+		interval := idleTimeout / 2
+		if interval < minPacketReapInterval {
+			interval = minPacketReapInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-server.sigchan:
+				err := packetConn.Close()
+				if err != nil {
+					server.log(Event{Kind: EventError, Message: "closing packet socket", Err: err})
+				}
+				sessions.closeAll()
 				return
+			case <-ticker.C:
+				sessions.expire(idleTimeout)
 			}
-			log.Println(err.Error())
 		}
 	}()
 
-	serverWaitGroup.Add(1)
-	go server.listen(&serverSocket, connChan, &closeFlag, serverWaitGroup)
-	log.Println("Service started successfully!")
-
-fl:
+	buf := make([]byte, maxDatagramSize)
+	var retryDelay time.Duration
 	for {
-		select {
-		case <-server.sigchan:
-			err = serverSocket.Close()
+		n, addr, err := packetConn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-server.sigchan:
+				return
+			default:
+			}
+			server.log(Event{Kind: EventError, Message: "reading datagram", Err: err})
+
+			//Like failed accepts, failed reads do not stop the server. The delay keeps a broken socket from spinning.
+			if retryDelay == 0 {
+				retryDelay = minReadRetryDelay
+			} else if retryDelay *= 2; retryDelay > maxReadRetryDelay {
+				retryDelay = maxReadRetryDelay
+			}
+			timer := time.NewTimer(retryDelay)
+			select {
+			case <-server.sigchan:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		retryDelay = 0
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		session, ok := sessions.get(addr)
+		if !ok {
+			if !server.admission.tryAcquire() {
+				atomic.AddInt64(&server.rejected, 1)
+				continue
+			}
+
+			session = sessions.create(addr)
+			conn := server.newConn(session)
+			if !server.trackConn(conn) {
+				server.admission.release()
+				session.Close()
+				continue
+			}
+			server.serve(conn, HandlerFunc(func(ctx context.Context, conn *Conn) error {
+				defer conn.Close()
+				return handler.ServeConn(ctx, conn)
+			}))
+		}
+		session.deliver(datagram)
+	}
+}
+
+//newConn wraps netConn in a Conn with the defaults of the server.
+func (server *Server) newConn(netConn net.Conn) *Conn {
+	conn := newConnContext(server.ctx, netConn, server.defaultTimeout, server.defaultMaxReadBuffer)
+	conn.readTimeout = server.readTimeout
+	conn.writeTimeout = server.writeTimeout
+	conn.logger = server.logger
+	return conn
+}
+
+//serve runs handler for conn in its own routine. conn has to be tracked by the server and hold an admission slot.
+//The PROXY header, filters, TLS and compression handshakes run first. conn is listed in Connections once they succeeded.
+func (server *Server) serve(conn *Conn, handler Handler) {
+	atomic.AddInt64(&server.metrics.accepted, 1)
+
+	go func() {
+		defer server.connWaitGroup.Done()
+		defer server.admission.release()
+		defer server.untrackConn(conn)
+
+		if !server.proto.packetBased() {
+			err := server.readProxyHeader(conn)
 			if err != nil {
-				log.Println(err.Error())
+				atomic.AddInt64(&server.metrics.failed, 1)
+				conn.log(Event{Kind: EventHandshakeFailed, Message: "proxy protocol", Err: err})
+				conn.Close()
+				return
 			}
-			closeFlag = true
-			break fl
+		}
 
-		case netConn := <-connChan:
-			conn := NewConn(*netConn, server.defaultTimeout, server.defaultMaxReadBuffer)
+		release, err := server.filter(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		defer release()
+
+		if server.tlsConfig != nil {
+			conn.Conn = tls.Server(conn.Conn, server.tlsConfig)
+		}
+		err = conn.handshake(conn.ctx)
+		if err != nil {
+			atomic.AddInt64(&server.metrics.failed, 1)
+			conn.log(Event{Kind: EventHandshakeFailed, Err: err})
+			conn.Close()
+			return
+		}
+		if len(server.compression.Methods) > 0 && !server.proto.messageBased() {
+			err = conn.negotiateCompression(conn.ctx, server.compression, false)
+			if err != nil {
+				atomic.AddInt64(&server.metrics.failed, 1)
+				conn.log(Event{Kind: EventHandshakeFailed, Message: "compression", Err: err})
+				conn.Close()
+				return
+			}
+		}
+		server.publishConn(conn)
+		atomic.AddInt64(&server.curClients, 1)
+		defer atomic.AddInt64(&server.curClients, -1)
+		conn.markOpened()
+		conn.StartHeartbeat(server.heartbeat)
 
-			connWaitGroup.Add(1)
-			atomic.AddInt64(&server.curClients, 1)
+		start := time.Now()
+		err = server.runHandler(handler, conn)
+		server.metrics.handlerDuration.observe(time.Since(start).Seconds())
+		if err != nil {
+			server.handleError(conn, err)
+		}
+	}()
+}
 
-			go func() {
-				defer connWaitGroup.Done()
-				defer atomic.AddInt64(&server.curClients, -1)
-				handle(conn, a...)
-			}()
+//runHandler serves conn with handler. A panic of handler is recovered, conn is closed and a *PanicError is returned.
+func (server *Server) runHandler(handler Handler, conn *Conn) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
 		}
+
+		atomic.AddInt64(&server.metrics.panics, 1)
+		panicErr := &PanicError{Value: r, Stack: debug.Stack()}
+		conn.log(Event{Kind: EventHandlerPanic, Message: string(panicErr.Stack), Err: panicErr})
+		conn.Close()
+		err = panicErr
+	}()
+	return handler.ServeConn(conn.Context(), conn)
+}
+
+//handleError records err of the handler of conn and passes it to the error handler of the server.
+func (server *Server) handleError(conn *Conn, err error) {
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		atomic.AddInt64(&server.metrics.handlerErrors, 1)
+		conn.log(Event{Kind: EventHandlerError, Err: err})
+	}
+
+	if server.errorHandler != nil {
+		server.errorHandler(conn, err)
 	}
 }
 
-func (server *Server) listen(socket *net.Listener, connChan chan *net.Conn, closeFlag *bool, serverWaitGroup *sync.WaitGroup) {
+//listen accepts connections on socket until the server is stopped.
+//With OverloadWait an accepted connection waits for a free slot before the next one is accepted,
+//otherwise surplus connections are rejected. Slots are only taken for accepted connections,
+//so listeners waiting in Accept do not hold slots of each other.
+func (server *Server) listen(socket net.Listener, handler Handler, serverWaitGroup *sync.WaitGroup) {
 	defer serverWaitGroup.Done()
-	defer close(connChan)
+	wait := server.overloadPolicy == OverloadWait
 
-fl:
 	for {
-		select {
-		case <-server.sigchan:
-			break fl
-		default:
-			if server.maxClients > 0 && server.curClients >= server.maxClients {
-				continue
+		netConn, err := socket.Accept()
+		if err != nil {
+			select {
+			case <-server.sigchan:
+				return
+			default:
 			}
-
-			netConn, err := (*socket).Accept()
-			if err != nil {
-				if *closeFlag {
-					continue
-				}
-				log.Println("Failed at accepting new connection: " + err.Error())
-				continue
+			if atomic.LoadInt32(&server.handedOff) == 1 {
+				return
 			}
+			atomic.AddInt64(&server.metrics.failed, 1)
+			server.log(Event{Kind: EventAcceptFailed, Err: err})
+			continue
+		}
+
+		if wait && !server.admission.acquire() {
+			netConn.Close()
+			return
+		}
+		if !wait && !server.admission.tryAcquire() {
+			server.reject(netConn)
+			continue
+		}
+
+		conn := server.newConn(netConn)
+		if !server.trackConn(conn) {
+			server.admission.release()
+			netConn.Close()
+			return
+		}
+		server.serve(conn, handler)
+	}
+}
+
+//addresses returns the addresses to listen on with port, one per bind ip.
+func (server *Server) addresses(port int) []string {
+	if server.proto.unixBased() {
+		return []string{server.path}
+	}
+	if len(server.bindIPs) == 0 {
+		return []string{fmt.Sprintf(":%s", strconv.Itoa(port))}
+	}
+
+	addresses := make([]string, len(server.bindIPs))
+	for i, ip := range server.bindIPs {
+		addresses[i] = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	}
+	return addresses
+}
+
+//addrPort returns the port of a tcp or udp address, 0 for other addresses.
+func addrPort(addr net.Addr) int {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+	return 0
+}
+
+//removeStaleSocket removes the socket file at path if no server accepts connections on it anymore.
+//Returns an error if path is in use or is not a socket.
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-			connChan <- &netConn
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+//trackConn assigns conn a new id and adds it to the connWaitGroup.
+//Returns false if the server is already stopped, in which case conn must not be served.
+func (server *Server) trackConn(conn *Conn) bool {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+
+	if server.stopped {
+		return false
+	}
+	server.nextConnID++
+	conn.id = server.nextConnID
+	server.connWaitGroup.Add(1)
+	return true
+}
+
+//publishConn lists conn in the connections of the server. conn must not be wrapped anymore afterwards,
+//since other routines access it from then on.
+func (server *Server) publishConn(conn *Conn) {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+	server.conns[conn.id] = conn
+}
+
+//untrackConn removes conn from the server, adds its bytes to the metrics and cancels its context.
+func (server *Server) untrackConn(conn *Conn) {
+	server.connsMu.Lock()
+	delete(server.conns, conn.id)
+	server.metrics.bytesRead += conn.BytesRead()
+	server.metrics.bytesWritten += conn.BytesWritten()
+	server.connsMu.Unlock()
+	server.metrics.connBytesRead.observe(float64(conn.BytesRead()))
+	server.metrics.connBytesWritten.observe(float64(conn.BytesWritten()))
+	conn.cancel()
+}
+
+//closeConns force closes all tracked connections and returns the amount of closed connections.
+func (server *Server) closeConns() int {
+	server.connsMu.Lock()
+	defer server.connsMu.Unlock()
+
+	for _, conn := range server.conns {
+		err := conn.Close()
+		if err != nil {
+			conn.log(Event{Kind: EventError, Message: "force closing connection", Err: err})
 		}
 	}
+	return len(server.conns)
 }
 
-func cleanup(connWaitGroup *sync.WaitGroup) {
-	log.Println("Caught shutdown signal. Shutting down server ...")
-	connWaitGroup.Wait()
-	log.Println("Successfully shut down server!")
+//cleanup waits for all handle functions to return after the server was stopped.
+func (server *Server) cleanup() {
+	server.log(Event{Kind: EventServerStopping})
+	server.connWaitGroup.Wait()
+	server.log(Event{Kind: EventServerStopped})
 }
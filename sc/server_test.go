@@ -1 +1,397 @@
-package sc
\ No newline at end of file
+package sc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//loopback returns the address of server on the loopback interface for net.Dial.
+func loopback(server *Server) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(addrPort(server.Addr())))
+}
+
+//freePort returns a tcp port on the loopback interface nothing listens on.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return addrPort(listener.Addr())
+}
+
+//waitForClients polls server until it serves n clients or the deadline passes.
+func waitForClients(t *testing.T, server *Server, n int64) {
+	deadline := time.Now().Add(2 * time.Second)
+	for server.CurClients() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d clients, got %d", n, server.CurClients())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		<-conn.Context().Done()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	netConn, err := net.Dial("tcp", loopback(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netConn.Close()
+	waitForClients(t, server, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected clean shutdown, got %s", err)
+	}
+	wg.Wait()
+}
+
+func TestServerShutdownForceClose(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		b := make([]byte, 16)
+		for {
+			if _, err := conn.Read(b); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		netConn, err := net.Dial("tcp", loopback(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer netConn.Close()
+	}
+	waitForClients(t, server, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("Expected *ShutdownError, got %v", err)
+	}
+	if shutdownErr.Killed != 2 {
+		t.Fatalf("Expected 2 killed connections, got %d", shutdownErr.Killed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected error to wrap context.DeadlineExceeded, got %s", err)
+	}
+	wg.Wait()
+}
+
+func TestUDPServerSessions(t *testing.T) {
+	server := NewUDPServer(0, 0, 1024, 0)
+	server.SetPacketIdleTimeout(100 * time.Millisecond)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		b := make([]byte, 64)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			conn.Write(append([]byte(conn.RemoteAddr().String()+":"), b[:n]...))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	peers := make([]net.Conn, 2)
+	for i := range peers {
+		peer, err := net.Dial("udp", loopback(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		peers[i] = peer
+	}
+
+	for _, peer := range peers {
+		peer.SetDeadline(time.Now().Add(time.Second))
+		if _, err := peer.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 64)
+		n, err := peer.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := peer.LocalAddr().String() + ":ping"
+		if string(b[:n]) != expected {
+			t.Fatalf("Expected %q, got %q", expected, b[:n])
+		}
+	}
+	waitForClients(t, server, 2)
+
+	//Sessions expire after the idle timeout and their handlers return.
+	waitForClients(t, server, 0)
+}
+
+func TestUDPServerTinyIdleTimeout(t *testing.T) {
+	server := NewUDPServer(0, 0, 1024, 0)
+	server.SetPacketIdleTimeout(time.Nanosecond)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Stop()
+	wg.Wait()
+}
+
+//flakyPacketConn fails its first read.
+type flakyPacketConn struct {
+	net.PacketConn
+	failed int32
+}
+
+func (pc *flakyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if atomic.CompareAndSwapInt32(&pc.failed, 0, 1) {
+		return 0, nil, errors.New("transient")
+	}
+	return pc.PacketConn.ReadFrom(b)
+}
+
+func TestUDPServerSurvivesReadError(t *testing.T) {
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewUDPServer(0, 0, 1024, 0)
+	server.SetLogger(NopLogger)
+	var serverWaitGroup sync.WaitGroup
+	serverWaitGroup.Add(1)
+	go server.listenAndServePackets(&flakyPacketConn{PacketConn: packetConn}, &serverWaitGroup, HandlerFunc(func(ctx context.Context, conn *Conn) error {
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil
+		}
+		_, err = conn.Write(b[:n])
+		return err
+	}))
+	defer serverWaitGroup.Wait()
+	defer server.Stop()
+
+	peer, err := net.Dial("udp", packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	peer.SetDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	if n, err := peer.Read(b); err != nil || string(b[:n]) != "ping" {
+		t.Fatalf("Expected ping after a failed read, got %q (%v)", b[:n], err)
+	}
+}
+
+func TestUnixServerPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only available on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "sc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sc.sock")
+
+	//Leave a stale socket file behind that the server has to remove.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	creds := make(chan *PeerCredentials, 1)
+	server := NewUnixServer(path, 0600, time.Second, 1024, 0)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		cred, err := conn.PeerCredentials()
+		if err != nil {
+			t.Error(err)
+		}
+		creds <- cred
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected permissions 0600, got %s", info.Mode().Perm())
+	}
+
+	conn, err := NewUnixClient(path, time.Second, 1024).Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(make([]byte, 1))
+	conn.Close()
+
+	cred := <-creds
+	if cred == nil || int(cred.PID) != os.Getpid() || int(cred.UID) != os.Getuid() {
+		t.Fatalf("Unexpected peer credentials %+v", cred)
+	}
+}
+
+func TestServerBind(t *testing.T) {
+	server := NewTCPServer(0, 0, 1024, 0)
+	server.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	if server.Addr() != nil {
+		t.Fatalf("Expected no address before Start, got %s", server.Addr())
+	}
+
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		conn.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	addr, ok := server.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) || addr.Port == 0 {
+		t.Fatalf("Expected bound loopback address with an ephemeral port, got %v", server.Addr())
+	}
+
+	//The server is ready as soon as Start returns.
+	netConn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	netConn.Close()
+
+	taken := NewTCPServer(addr.Port, 0, 1024, 0)
+	taken.SetBindIPs(net.IPv4(127, 0, 0, 1))
+	if _, err := taken.Start(func(conn *Conn, a ...interface{}) {}); err == nil {
+		t.Fatal("Expected bind error for a port in use")
+	}
+}
+
+func TestServerBindSharedPort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Only linux routes all of 127.0.0.0/8 to the loopback interface")
+	}
+
+	testCases := []struct {
+		desc   string
+		server *Server
+	}{
+		{desc: "tcp", server: NewTCPServer(0, 0, 1024, 0)},
+		{desc: "udp", server: NewUDPServer(0, 0, 1024, 0)},
+	}
+	for _, tC := range testCases {
+		tC := tC
+		t.Run(tC.desc, func(t *testing.T) {
+			server := tC.server
+			server.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2))
+			server.SetLogger(NopLogger)
+			wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+				conn.Close()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer wg.Wait()
+			defer server.Stop()
+
+			addrs := server.Addrs()
+			if len(addrs) != 2 || addrPort(addrs[0]) == 0 || addrPort(addrs[0]) != addrPort(addrs[1]) {
+				t.Fatalf("Expected both bind ips on the same ephemeral port, got %v", addrs)
+			}
+		})
+	}
+}
+
+func TestUnixPacketServerLargeMessage(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unixpacket sockets are only tested on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "sc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sc.sock")
+
+	server := NewUnixPacketServer(path, 0600, time.Second, 1<<16, 0)
+	server.SetLogger(NopLogger)
+	wg, err := server.Start(func(conn *Conn, a ...interface{}) {
+		defer conn.Close()
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.WriteMessage(msg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wg.Wait()
+	defer server.Stop()
+
+	conn, err := NewUnixPacketClient(path, time.Second, 1<<16).Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := bytes.Repeat([]byte("a"), 10000)
+	if err := conn.WriteMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	echo, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, msg) {
+		t.Fatalf("Expected echo of %d bytes, got %d bytes", len(msg), len(echo))
+	}
+}
@@ -0,0 +1,36 @@
+// +build !windows
+
+package sc
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+//umaskMu serializes changes of the process wide umask by sc.
+var umaskMu sync.Mutex
+
+//listenUnixPerm listens on the unix domain socket at path with the permissions perm.
+//The socket is created under a umask that grants no more than perm, so nobody can connect before
+//the permissions are applied. The umask is process wide, files created concurrently by other routines
+//get at most perm as well while the socket is created.
+func listenUnixPerm(network, path string, perm os.FileMode) (net.Listener, error) {
+	umaskMu.Lock()
+	old := syscall.Umask(int(0777 &^ perm.Perm()))
+	socket, err := net.Listen(network, path)
+	syscall.Umask(old)
+	umaskMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	//The umask can only take away permissions, perm is applied exactly afterwards.
+	err = os.Chmod(path, perm)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return socket, nil
+}
@@ -0,0 +1,22 @@
+package sc
+
+import (
+	"net"
+	"os"
+)
+
+//listenUnixPerm listens on the unix domain socket at path and applies perm afterwards.
+//Windows has no umask, the permissions of the socket file are not enforced for connecting anyway.
+func listenUnixPerm(network, path string, perm os.FileMode) (net.Listener, error) {
+	socket, err := net.Listen(network, path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, perm)
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return socket, nil
+}